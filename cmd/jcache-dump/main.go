@@ -0,0 +1,65 @@
+// Command jcache-dump streams a full snapshot of a jcache storage backend
+// to a file, using the framed, checksummed format package snapshot
+// defines. The resulting file can seed jcache-load, back up a running
+// instance, migrate data between backends, or seed a replica of a Raft
+// cluster before it joins.
+//
+// This opens the backend file directly in-process; package server has no
+// SNAPSHOT command to talk to over a client connection. Dumping a boltdb
+// file already held open by a live server therefore fails with a
+// lock-timeout error (see boltdb.NewStorage) rather than succeeding
+// without blocking that server's traffic.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/Barberrrry/jcache/server/snapshot"
+	"github.com/Barberrrry/jcache/server/storage"
+	"github.com/Barberrrry/jcache/server/storage/registry"
+
+	// Blank-imported so their init() functions can register their DSN
+	// scheme with the registry package.
+	_ "github.com/Barberrrry/jcache/server/storage/badger"
+	_ "github.com/Barberrrry/jcache/server/storage/boltdb"
+	_ "github.com/Barberrrry/jcache/server/storage/pebble"
+)
+
+func main() {
+	storageType := flag.String("storage_type", "", "Storage DSN to dump from (boltdb:///path, badger:///path, pebble:///path)")
+	out := flag.String("out", "", "Path to write the snapshot to")
+	after := flag.String("after", "", "Resume cursor of the last record a previously interrupted dump wrote, to continue it instead of starting over")
+	flag.Parse()
+
+	if *storageType == "" || *out == "" {
+		log.Fatal("both -storage_type and -out are required")
+	}
+
+	s, err := registry.Open(*storageType)
+	if err != nil {
+		log.Fatalf("cannot open storage: %s", err)
+	}
+
+	dump, ok := s.(storage.Dumper)
+	if !ok {
+		log.Fatalf("storage %q does not support dumping", *storageType)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if *after == "" {
+		flags |= os.O_TRUNC
+	} else {
+		flags |= os.O_APPEND
+	}
+	f, err := os.OpenFile(*out, flags, 0644)
+	if err != nil {
+		log.Fatalf("cannot open %q: %s", *out, err)
+	}
+	defer f.Close()
+
+	if err := snapshot.Write(f, dump, *after); err != nil {
+		log.Fatalf("cannot write snapshot: %s", err)
+	}
+}
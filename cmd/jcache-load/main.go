@@ -0,0 +1,58 @@
+// Command jcache-load streams a snapshot produced by jcache-dump into a
+// fresh jcache storage backend, backing backup restores, backend
+// migrations and replica seeding.
+//
+// It loads by opening the backend file directly in-process; package
+// server has no RESTORE command to talk to over a client connection.
+// Point this at a fresh, empty instance only - Load does not check for
+// pre-existing keys.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/Barberrrry/jcache/server/snapshot"
+	"github.com/Barberrrry/jcache/server/storage"
+	"github.com/Barberrrry/jcache/server/storage/registry"
+
+	// Blank-imported so their init() functions can register their DSN
+	// scheme with the registry package.
+	_ "github.com/Barberrrry/jcache/server/storage/badger"
+	_ "github.com/Barberrrry/jcache/server/storage/boltdb"
+	_ "github.com/Barberrrry/jcache/server/storage/pebble"
+)
+
+func main() {
+	storageType := flag.String("storage_type", "", "Storage DSN to load into (boltdb:///path, badger:///path, pebble:///path); should be empty")
+	in := flag.String("in", "", "Path to read the snapshot from")
+	flag.Parse()
+
+	if *storageType == "" || *in == "" {
+		log.Fatal("both -storage_type and -in are required")
+	}
+
+	s, err := registry.Open(*storageType)
+	if err != nil {
+		log.Fatalf("cannot open storage: %s", err)
+	}
+
+	load, ok := s.(storage.Loader)
+	if !ok {
+		log.Fatalf("storage %q does not support loading", *storageType)
+	}
+
+	f, err := os.Open(*in)
+	if err != nil {
+		log.Fatalf("cannot open %q: %s", *in, err)
+	}
+	defer f.Close()
+
+	resumeToken, err := snapshot.Read(f, load)
+	if err != nil {
+		log.Fatalf("cannot load snapshot (re-run jcache-dump with -after %q to resume): %s", resumeToken, err)
+	}
+
+	log.Printf("loaded snapshot into %q", *storageType)
+}
@@ -2,32 +2,113 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/Barberrrry/jcache/server"
-	"github.com/Barberrrry/jcache/server/memory"
+	"github.com/Barberrrry/jcache/server/cluster"
+	"github.com/Barberrrry/jcache/server/storage/registry"
+	"github.com/Barberrrry/jcache/server/user"
+
+	// Blank-imported so their init() functions can register their DSN
+	// scheme with the registry package.
+	_ "github.com/Barberrrry/jcache/server/memory"
+	_ "github.com/Barberrrry/jcache/server/storage/badger"
+	_ "github.com/Barberrrry/jcache/server/storage/boltdb"
+	_ "github.com/Barberrrry/jcache/server/storage/pebble"
 )
 
-func main() {
-	storageType := server.StorageType(server.StorageMemory)
+// leaderElectionTimeout bounds how long a freshly joined node waits for
+// the cluster to elect some leader before falling through to user store
+// setup anyway.
+const leaderElectionTimeout = 10 * time.Second
 
-	htpasswdPath := flag.String("htpasswd", "", "Path to .htpasswd file for authentication. Leave blank to disable authentication.")
+func main() {
+	htpasswdPath := flag.String("htpasswd", "", "Path to .htpasswd file. If set, its bcrypt-hashed users are imported into the storage-backed user store and the process exits.")
 	listen := flag.String("listen", ":9999", "Host and port to listen connection")
-	flag.Var(&storageType, "storage_type", "Type of storage (memory, multi_memory)")
-	storageMultiMemoryCount := flag.Uint("storage_multi_memory_count", 1, "Number of storages inside multi memory storage")
+	storageType := flag.String("storage_type", "memory://", "Storage DSN (memory://, multi_memory://?shards=N, boltdb:///path, badger:///path, pebble:///path)")
+	nodeID := flag.String("node_id", "", "This node's unique Raft server ID. Leave blank to run without cluster replication.")
+	raftDir := flag.String("raft_dir", "", "Directory to store this node's Raft log and snapshots")
+	clusterPeers := flag.String("cluster_peers", "", "Comma separated list of cluster peers as id=host:port, including this node")
 	flag.Parse()
 
-	var storage server.Storage
+	log.Printf(`storage type is "%s"`, *storageType)
+
+	storage, err := registry.Open(*storageType)
+	if err != nil {
+		log.Fatalf("cannot open storage: %s", err)
+	}
 
-	log.Printf(`storage type is "%s"`, storageType)
+	if *nodeID != "" {
+		var peers []string
+		if *clusterPeers != "" {
+			peers = strings.Split(*clusterPeers, ",")
+		}
 
-	switch storageType {
-	case server.StorageMemory:
-		storage = memory.NewStorage()
-	case server.StorageMultiMemory:
-		storage = memory.NewMultiStorage(*storageMultiMemoryCount)
+		raftBind, err := clusterRaftBind(*nodeID, peers)
+		if err != nil {
+			log.Fatalf("cannot start cluster: %s", err)
+		}
+
+		c, err := cluster.New(storage, cluster.Config{
+			NodeID:   *nodeID,
+			RaftBind: raftBind,
+			RaftDir:  *raftDir,
+			Peers:    peers,
+		})
+		if err != nil {
+			log.Fatalf("cannot start cluster: %s", err)
+		}
+		storage = c
+
+		log.Printf(`node "%s" joined cluster of %d peers`, *nodeID, len(peers))
+
+		// BootstrapCluster returns before an election completes, so every
+		// node would otherwise race user store setup against a cluster
+		// with no leader yet. Wait for any node to become leader first;
+		// user.NewStore/Bootstrap tolerate commonStorage.NotLeaderError
+		// from whichever nodes end up being followers.
+		if err := c.WaitForLeader(leaderElectionTimeout); err != nil {
+			log.Printf("warning: %s; continuing, user store setup will rely on replication once a leader appears", err)
+		}
 	}
 
-	s := server.New(storage, *htpasswdPath)
+	// userStore is built from the final storage handle (cluster-wrapped,
+	// when clustering is enabled) so that Bootstrap and every later
+	// Add/Delete/Passwd call is replicated through Raft like any other
+	// write, instead of landing only on this node's local backend.
+	userStore, err := user.NewStore(storage)
+	if err != nil {
+		log.Fatalf("cannot open user store: %s", err)
+	}
+
+	if *htpasswdPath != "" {
+		imported, err := user.ImportHtpasswd(userStore, *htpasswdPath)
+		if err != nil {
+			log.Fatalf("cannot import htpasswd file: %s", err)
+		}
+		log.Printf("imported %d users from %s", imported, *htpasswdPath)
+		return
+	}
+
+	if err := userStore.Bootstrap(); err != nil {
+		log.Fatalf("cannot bootstrap user store: %s", err)
+	}
+
+	s := server.New(storage, userStore)
 	s.ListenAndServe(*listen)
 }
+
+// clusterRaftBind finds the host:port this node should bind its Raft
+// transport to by looking up nodeID in the id=host:port peer list.
+func clusterRaftBind(nodeID string, peers []string) (string, error) {
+	for _, peer := range peers {
+		parts := strings.SplitN(peer, "=", 2)
+		if len(parts) == 2 && parts[0] == nodeID {
+			return parts[1], nil
+		}
+	}
+	return "", fmt.Errorf("node_id %q not found in cluster_peers", nodeID)
+}
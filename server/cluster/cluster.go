@@ -0,0 +1,359 @@
+// Package cluster wraps a storage.Storage with Raft-based replication,
+// turning a single jcache instance into one node of a replicated
+// key-value cluster. Every mutating call is proposed as a log entry and
+// only applied to the local storage once the Raft group has committed
+// it; reads are served from the local copy.
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+
+	commonStorage "github.com/Barberrrry/jcache/server/storage"
+)
+
+const raftTimeout = 10 * time.Second
+
+// expiryScanInterval is how often the leader scans for keys due to
+// expire when storage implements commonStorage.ExpiryScanner.
+const expiryScanInterval = time.Second
+
+// Config describes how a node joins or bootstraps a Raft cluster.
+type Config struct {
+	// NodeID is this node's unique Raft server ID.
+	NodeID string
+	// RaftBind is the host:port the Raft transport listens on.
+	RaftBind string
+	// RaftDir stores the Raft log, stable store and snapshots.
+	RaftDir string
+	// Peers lists every node in the cluster as "id=host:port", including
+	// this one. The cluster is bootstrapped from this list on first start.
+	Peers []string
+	// StaleReads allows reads to be served by any node instead of
+	// requiring the caller to talk to the current leader.
+	StaleReads bool
+}
+
+// Cluster is a storage.Storage backed by a Raft-replicated log.
+type Cluster struct {
+	storage commonStorage.Storage
+	raft    *raft.Raft
+	stale   bool
+}
+
+// New wraps storage with Raft replication as described by cfg.
+func New(storage commonStorage.Storage, cfg Config) (*Cluster, error) {
+	raftConfig := raft.DefaultConfig()
+	raftConfig.LocalID = raft.ServerID(cfg.NodeID)
+
+	addr, err := net.ResolveTCPAddr("tcp", cfg.RaftBind)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve raft bind address: %s", err)
+	}
+	transport, err := raft.NewTCPTransport(cfg.RaftBind, addr, 3, raftTimeout, os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create raft transport: %s", err)
+	}
+
+	if err := os.MkdirAll(cfg.RaftDir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create raft dir: %s", err)
+	}
+	snapshots, err := raft.NewFileSnapshotStore(cfg.RaftDir, 2, os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create raft snapshot store: %s", err)
+	}
+	logStore, err := raftboltdb.NewBoltStore(filepath.Join(cfg.RaftDir, "raft-log.db"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create raft log store: %s", err)
+	}
+	stableStore, err := raftboltdb.NewBoltStore(filepath.Join(cfg.RaftDir, "raft-stable.db"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create raft stable store: %s", err)
+	}
+
+	r, err := raft.NewRaft(raftConfig, &fsm{storage: storage}, logStore, stableStore, snapshots, transport)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create raft node: %s", err)
+	}
+
+	servers, err := parsePeers(cfg.Peers)
+	if err != nil {
+		return nil, err
+	}
+	// BootstrapCluster is a no-op once the cluster already has state, so
+	// it is safe to call this on every restart of every node.
+	r.BootstrapCluster(raft.Configuration{Servers: servers})
+
+	cl := &Cluster{storage: storage, raft: r, stale: cfg.StaleReads}
+
+	// TTL expiry is driven from the leader's clock and replicated via the
+	// Raft log instead of each node expiring the same key independently.
+	// A backend that cannot report which keys are due to expire has no
+	// way to participate in that, so each node would fall back to its
+	// own wall-clock GC and could diverge from its peers on exactly the
+	// same key - acceptable only if the caller already opted into serving
+	// possibly-stale local reads.
+	scanner, hasScanner := storage.(commonStorage.ExpiryScanner)
+	if !hasScanner && !cfg.StaleReads {
+		return nil, fmt.Errorf("cluster: storage %T cannot report expiring keys, so its local GC would diverge across nodes; enable StaleReads to accept that or use a backend implementing storage.ExpiryScanner", storage)
+	}
+	if stopper, ok := storage.(commonStorage.GCStopper); ok {
+		stopper.StopGC()
+	}
+	if hasScanner {
+		go cl.expireLoop(scanner)
+	}
+
+	return cl, nil
+}
+
+// expireLoop runs for the node's lifetime. On the current leader it
+// periodically asks scanner for keys that are due to expire and proposes
+// their removal through apply, so every node deletes them at the same
+// point in the replicated log instead of racing its own local GC against
+// the same wall-clock deadline. Non-leader nodes skip the scan and rely
+// on the resulting replicated deletes.
+func (c *Cluster) expireLoop(scanner commonStorage.ExpiryScanner) {
+	ticker := time.NewTicker(expiryScanInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if c.raft.State() != raft.Leader {
+			continue
+		}
+
+		keys, err := scanner.ExpiringKeys(time.Now())
+		if err != nil {
+			continue
+		}
+		for _, key := range keys {
+			c.apply(command{Op: opDelete, Key: key})
+		}
+	}
+}
+
+func parsePeers(peers []string) ([]raft.Server, error) {
+	servers := make([]raft.Server, 0, len(peers))
+	for _, peer := range peers {
+		parts := strings.SplitN(peer, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("cluster: invalid peer %q, expected id=host:port", peer)
+		}
+		servers = append(servers, raft.Server{
+			ID:      raft.ServerID(parts[0]),
+			Address: raft.ServerAddress(parts[1]),
+		})
+	}
+	return servers, nil
+}
+
+// Status reports the current Raft term, leader and known peers. No
+// protocol command in package server exposes it.
+type Status struct {
+	State  string
+	Term   uint64
+	Leader string
+	Peers  []string
+}
+
+// Status returns the current state of this node's view of the cluster.
+func (c *Cluster) Status() Status {
+	term, _ := strconv.ParseUint(c.raft.Stats()["term"], 10, 64)
+	status := Status{
+		State:  c.raft.State().String(),
+		Term:   term,
+		Leader: string(c.raft.Leader()),
+	}
+	if future := c.raft.GetConfiguration(); future.Error() == nil {
+		for _, server := range future.Configuration().Servers {
+			status.Peers = append(status.Peers, string(server.ID))
+		}
+	}
+	return status
+}
+
+// WaitForLeader blocks until the cluster has a known leader (not
+// necessarily this node) or timeout elapses. BootstrapCluster returns
+// before leader election completes, so a caller that needs a leader to
+// exist before proceeding - such as bootstrapping the initial admin user
+// - should call this first instead of racing the election.
+func (c *Cluster) WaitForLeader(timeout time.Duration) error {
+	if c.raft.Leader() != "" {
+		return nil
+	}
+
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-deadline:
+			return fmt.Errorf("cluster: no leader elected after %s", timeout)
+		case <-ticker.C:
+			if c.raft.Leader() != "" {
+				return nil
+			}
+		}
+	}
+}
+
+func (c *Cluster) checkStaleness() error {
+	if c.stale {
+		return nil
+	}
+	if c.raft.State() != raft.Leader {
+		return fmt.Errorf("%w, leader is %q", commonStorage.NotLeaderError, c.raft.Leader())
+	}
+	return nil
+}
+
+func (c *Cluster) apply(cmd command) applyResult {
+	if c.raft.State() != raft.Leader {
+		return applyResult{Err: fmt.Errorf("%w, leader is %q", commonStorage.NotLeaderError, c.raft.Leader())}
+	}
+
+	data, err := encodeCommand(cmd)
+	if err != nil {
+		return applyResult{Err: err}
+	}
+
+	future := c.raft.Apply(data, raftTimeout)
+	if err := future.Error(); err != nil {
+		return applyResult{Err: err}
+	}
+
+	result, _ := future.Response().(applyResult)
+	return result
+}
+
+// Keys returns list of all keys.
+func (c *Cluster) Keys() []string {
+	return c.storage.Keys()
+}
+
+// Expire sets new key ttl.
+func (c *Cluster) Expire(key string, ttl uint64) error {
+	return c.apply(command{Op: opExpire, Key: key, TTL: ttl}).Err
+}
+
+// Get returns value of specified key.
+func (c *Cluster) Get(key string) (string, error) {
+	if err := c.checkStaleness(); err != nil {
+		return "", err
+	}
+	return c.storage.Get(key)
+}
+
+// Set value of specified key with ttl.
+func (c *Cluster) Set(key, value string, ttl uint64) error {
+	return c.apply(command{Op: opSet, Key: key, Value: value, TTL: ttl}).Err
+}
+
+// Update value of specified key.
+func (c *Cluster) Update(key, value string) error {
+	return c.apply(command{Op: opUpdate, Key: key, Value: value}).Err
+}
+
+// Delete specified key.
+func (c *Cluster) Delete(key string) error {
+	return c.apply(command{Op: opDelete, Key: key}).Err
+}
+
+// HashCreate creates new hash with specified key and ttl.
+func (c *Cluster) HashCreate(key string, ttl uint64) error {
+	return c.apply(command{Op: opHashCreate, Key: key, TTL: ttl}).Err
+}
+
+// HashGet returns value of specified field of key.
+func (c *Cluster) HashGet(key, field string) (string, error) {
+	if err := c.checkStaleness(); err != nil {
+		return "", err
+	}
+	return c.storage.HashGet(key, field)
+}
+
+// HashGetAll returns all hash values of specified key.
+func (c *Cluster) HashGetAll(key string) (map[string]string, error) {
+	if err := c.checkStaleness(); err != nil {
+		return nil, err
+	}
+	return c.storage.HashGetAll(key)
+}
+
+// HashSet sets field value of specified key.
+func (c *Cluster) HashSet(key, field, value string) error {
+	return c.apply(command{Op: opHashSet, Key: key, Field: field, Value: value}).Err
+}
+
+// HashDelete deletes field from hash.
+func (c *Cluster) HashDelete(key, field string) error {
+	return c.apply(command{Op: opHashDelete, Key: key, Field: field}).Err
+}
+
+// HashLen returns count of hash fields.
+func (c *Cluster) HashLen(key string) (int, error) {
+	if err := c.checkStaleness(); err != nil {
+		return 0, err
+	}
+	return c.storage.HashLen(key)
+}
+
+// HashKeys returns list of all hash fields.
+func (c *Cluster) HashKeys(key string) ([]string, error) {
+	if err := c.checkStaleness(); err != nil {
+		return nil, err
+	}
+	return c.storage.HashKeys(key)
+}
+
+// ListCreate creates new list with specified key and ttl.
+func (c *Cluster) ListCreate(key string, ttl uint64) error {
+	return c.apply(command{Op: opListCreate, Key: key, TTL: ttl}).Err
+}
+
+// ListLeftPop pops value from the list beginning.
+func (c *Cluster) ListLeftPop(key string) (string, error) {
+	result := c.apply(command{Op: opListLeftPop, Key: key})
+	return result.Value, result.Err
+}
+
+// ListRightPop pops value from the list ending.
+func (c *Cluster) ListRightPop(key string) (string, error) {
+	result := c.apply(command{Op: opListRightPop, Key: key})
+	return result.Value, result.Err
+}
+
+// ListLeftPush adds value to the list beginning.
+func (c *Cluster) ListLeftPush(key, value string) error {
+	return c.apply(command{Op: opListLeftPush, Key: key, Value: value}).Err
+}
+
+// ListRightPush adds value to the list ending.
+func (c *Cluster) ListRightPush(key, value string) error {
+	return c.apply(command{Op: opListRightPush, Key: key, Value: value}).Err
+}
+
+// ListLen returns count of elements in the list.
+func (c *Cluster) ListLen(key string) (int, error) {
+	if err := c.checkStaleness(); err != nil {
+		return 0, err
+	}
+	return c.storage.ListLen(key)
+}
+
+// ListRange returns list of elements from the list from start to stop index.
+func (c *Cluster) ListRange(key string, start, stop int) ([]string, error) {
+	if err := c.checkStaleness(); err != nil {
+		return nil, err
+	}
+	return c.storage.ListRange(key, start, stop)
+}
@@ -0,0 +1,61 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// commandOp identifies which storage.Storage mutator a replicated command
+// should invoke once it is applied by the FSM.
+type commandOp string
+
+const (
+	opSet           commandOp = "SET"
+	opUpdate        commandOp = "UPDATE"
+	opDelete        commandOp = "DELETE"
+	opExpire        commandOp = "EXPIRE"
+	opHashCreate    commandOp = "HASH_CREATE"
+	opHashSet       commandOp = "HASH_SET"
+	opHashDelete    commandOp = "HASH_DELETE"
+	opListCreate    commandOp = "LIST_CREATE"
+	opListLeftPush  commandOp = "LIST_LEFT_PUSH"
+	opListRightPush commandOp = "LIST_RIGHT_PUSH"
+	opListLeftPop   commandOp = "LIST_LEFT_POP"
+	opListRightPop  commandOp = "LIST_RIGHT_POP"
+)
+
+// command is the record appended to the Raft log for every mutation.
+// It is intentionally flat so a single gob-registered type covers every
+// storage.Storage mutator.
+type command struct {
+	Op    commandOp
+	Key   string
+	Field string
+	Value string
+	TTL   uint64
+}
+
+// applyResult is what the FSM returns from Apply and what callers of
+// Cluster.apply receive back through the Raft future's Response().
+type applyResult struct {
+	Value string
+	Err   error
+}
+
+func init() {
+	gob.Register(command{})
+}
+
+func encodeCommand(c command) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(c); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeCommand(data []byte) (command, error) {
+	var c command
+	err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&c)
+	return c, err
+}
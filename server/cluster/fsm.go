@@ -0,0 +1,75 @@
+package cluster
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/hashicorp/raft"
+
+	commonStorage "github.com/Barberrrry/jcache/server/storage"
+)
+
+// fsm applies committed Raft log entries to the underlying storage. It is
+// the only thing allowed to mutate storage directly once a Cluster is in
+// charge of it; every other write goes through Cluster.apply and the log.
+type fsm struct {
+	storage commonStorage.Storage
+}
+
+func (f *fsm) Apply(log *raft.Log) interface{} {
+	cmd, err := decodeCommand(log.Data)
+	if err != nil {
+		return applyResult{Err: err}
+	}
+
+	switch cmd.Op {
+	case opSet:
+		return applyResult{Err: f.storage.Set(cmd.Key, cmd.Value, cmd.TTL)}
+	case opUpdate:
+		return applyResult{Err: f.storage.Update(cmd.Key, cmd.Value)}
+	case opDelete:
+		return applyResult{Err: f.storage.Delete(cmd.Key)}
+	case opExpire:
+		return applyResult{Err: f.storage.Expire(cmd.Key, cmd.TTL)}
+	case opHashCreate:
+		return applyResult{Err: f.storage.HashCreate(cmd.Key, cmd.TTL)}
+	case opHashSet:
+		return applyResult{Err: f.storage.HashSet(cmd.Key, cmd.Field, cmd.Value)}
+	case opHashDelete:
+		return applyResult{Err: f.storage.HashDelete(cmd.Key, cmd.Field)}
+	case opListCreate:
+		return applyResult{Err: f.storage.ListCreate(cmd.Key, cmd.TTL)}
+	case opListLeftPush:
+		return applyResult{Err: f.storage.ListLeftPush(cmd.Key, cmd.Value)}
+	case opListRightPush:
+		return applyResult{Err: f.storage.ListRightPush(cmd.Key, cmd.Value)}
+	case opListLeftPop:
+		value, err := f.storage.ListLeftPop(cmd.Key)
+		return applyResult{Value: value, Err: err}
+	case opListRightPop:
+		value, err := f.storage.ListRightPop(cmd.Key)
+		return applyResult{Value: value, Err: err}
+	default:
+		return applyResult{Err: fmt.Errorf("cluster: unknown replicated command %q", cmd.Op)}
+	}
+}
+
+// Snapshot and Restore satisfy raft.FSM without dumping any data: they do
+// not use storage.Dumper or storage.Loader. Persist cancels the sink, so
+// no Raft snapshot is ever stored, and Restore discards what it is given.
+// Raft therefore replays the full log to catch up a lagging or new node.
+type fsmSnapshot struct{}
+
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	return fsmSnapshot{}, nil
+}
+
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	return rc.Close()
+}
+
+func (fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	return sink.Cancel()
+}
+
+func (fsmSnapshot) Release() {}
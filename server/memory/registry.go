@@ -0,0 +1,27 @@
+package memory
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/Barberrrry/jcache/server/storage"
+	"github.com/Barberrrry/jcache/server/storage/registry"
+)
+
+func init() {
+	registry.Register("memory", func(dsn *url.URL) (storage.Storage, error) {
+		return NewStorage(), nil
+	})
+
+	registry.Register("multi_memory", func(dsn *url.URL) (storage.Storage, error) {
+		shards := uint64(1)
+		if raw := dsn.Query().Get("shards"); raw != "" {
+			parsed, err := strconv.ParseUint(raw, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			shards = parsed
+		}
+		return NewMultiStorage(uint(shards)), nil
+	})
+}
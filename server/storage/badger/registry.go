@@ -0,0 +1,25 @@
+package badger
+
+import (
+	"net/url"
+	"time"
+
+	commonStorage "github.com/Barberrrry/jcache/server/storage"
+	"github.com/Barberrrry/jcache/server/storage/registry"
+)
+
+const defaultGCInterval = 30 * time.Second
+
+func init() {
+	registry.Register("badger", func(dsn *url.URL) (commonStorage.Storage, error) {
+		gcInterval := defaultGCInterval
+		if raw := dsn.Query().Get("gc"); raw != "" {
+			parsed, err := time.ParseDuration(raw)
+			if err != nil {
+				return nil, err
+			}
+			gcInterval = parsed
+		}
+		return NewStorage(dsn.Path, gcInterval)
+	})
+}
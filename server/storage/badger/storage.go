@@ -0,0 +1,710 @@
+// Package badger implements storage.Storage on top of BadgerDB, sharing
+// the same type-prefixed key layout and ttl-index GC pattern as the
+// boltdb backend.
+package badger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+
+	commonStorage "github.com/Barberrrry/jcache/server/storage"
+)
+
+const (
+	stringPrefix = 's'
+	hashPrefix   = 'h'
+	listPrefix   = 'l'
+	ttlPrefix    = 't'
+)
+
+var typePrefixes = []byte{stringPrefix, hashPrefix, listPrefix}
+
+// Storage uses BadgerDB as a persistent file-based storage.
+// encoding/gob is used to encode/decode data structures to put them into
+// Badger. Keys carry a 1-byte type prefix and are mirrored into ttl-index
+// entries keyed by expiration time, so the GC loop can range over only
+// the expired keys instead of scanning the whole keyspace.
+type storage struct {
+	db     *badger.DB
+	stopGC chan struct{}
+}
+
+func init() {
+	gob.Register(commonStorage.Item{})
+	gob.Register(commonStorage.Hash{})
+	gob.Register(&commonStorage.List{})
+}
+
+// NewStorage opens dir as a Badger-backed storage and starts a background
+// goroutine that reaps expired keys every gcInterval.
+func NewStorage(dir string, gcInterval time.Duration) (*storage, error) {
+	db, err := badger.Open(badger.DefaultOptions(dir))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open Badger database: %s", err)
+	}
+
+	s := &storage{db: db, stopGC: make(chan struct{})}
+	go s.gc(gcInterval)
+
+	return s, nil
+}
+
+func dataKey(prefix byte, key string) []byte {
+	buf := make([]byte, 1+len(key))
+	buf[0] = prefix
+	copy(buf[1:], key)
+	return buf
+}
+
+// ttlKey builds a ttl-index key that sorts by expiration time first, so
+// the GC loop can range over just the expired prefix. dataKey is nil when
+// only used to build a scan boundary.
+func ttlKey(expireTime time.Time, dataKey []byte) []byte {
+	buf := make([]byte, 1+8+len(dataKey))
+	buf[0] = ttlPrefix
+	binary.BigEndian.PutUint64(buf[1:], uint64(expireTime.UnixNano()))
+	copy(buf[9:], dataKey)
+	return buf
+}
+
+func (s *storage) gc(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopGC:
+			return
+		case <-ticker.C:
+		}
+
+		boundary := ttlKey(time.Now(), nil)
+
+		var expiredTTLKeys, expiredDataKeys [][]byte
+		s.db.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = []byte{ttlPrefix}
+			it := txn.NewIterator(opts)
+			defer it.Close()
+
+			for it.Seek(opts.Prefix); it.ValidForPrefix(opts.Prefix); it.Next() {
+				badgerItem := it.Item()
+				key := badgerItem.KeyCopy(nil)
+				if bytes.Compare(key, boundary) >= 0 {
+					break
+				}
+				dataKey, err := badgerItem.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				expiredTTLKeys = append(expiredTTLKeys, key)
+				expiredDataKeys = append(expiredDataKeys, dataKey)
+			}
+			return nil
+		})
+
+		if len(expiredTTLKeys) == 0 {
+			continue
+		}
+
+		s.db.Update(func(txn *badger.Txn) error {
+			for i := range expiredTTLKeys {
+				txn.Delete(expiredTTLKeys[i])
+				txn.Delete(expiredDataKeys[i])
+			}
+			return nil
+		})
+	}
+}
+
+// StopGC stops the background GC goroutine started by NewStorage. It is
+// used by a cluster wrapping this storage to disable independent,
+// wall-clock-driven expiry in favor of leader-driven, replicated expiry
+// via ExpiringKeys. Calling it more than once panics, same as closing any
+// other channel twice.
+func (s *storage) StopGC() {
+	close(s.stopGC)
+}
+
+// ExpiringKeys returns every live key whose expiration time is at or
+// before cutoff, without deleting them. A cluster leader uses this to
+// discover expired keys and propose their removal through Raft so every
+// node deletes them at the same point in the replicated log instead of
+// each node's own GC racing to expire the same key independently.
+func (s *storage) ExpiringKeys(cutoff time.Time) ([]string, error) {
+	boundary := ttlKey(cutoff.Add(time.Nanosecond), nil)
+
+	var keys []string
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte{ttlPrefix}
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(opts.Prefix); it.ValidForPrefix(opts.Prefix); it.Next() {
+			badgerItem := it.Item()
+			if bytes.Compare(badgerItem.KeyCopy(nil), boundary) >= 0 {
+				break
+			}
+			dataKey, err := badgerItem.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if len(dataKey) < 1 {
+				continue
+			}
+			keys = append(keys, string(dataKey[1:]))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func decodeItem(data []byte) (*commonStorage.Item, error) {
+	var item commonStorage.Item
+	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func encodeItem(item *commonStorage.Item) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(item); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func getRaw(txn *badger.Txn, key []byte) ([]byte, error) {
+	badgerItem, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil, commonStorage.KeyNotExistsError
+	}
+	if err != nil {
+		return nil, err
+	}
+	return badgerItem.ValueCopy(nil)
+}
+
+// getItem looks up the item stored under key with the given type prefix.
+// It also returns the data key it was found at, so callers can pass it
+// straight to saveItem/deleteItem.
+func (s *storage) getItem(txn *badger.Txn, prefix byte, key string) (*commonStorage.Item, []byte, error) {
+	dk := dataKey(prefix, key)
+	raw, err := getRaw(txn, dk)
+	if err != nil {
+		return nil, dk, err
+	}
+
+	item, err := decodeItem(raw)
+	if err != nil {
+		return nil, dk, err
+	}
+	if !item.IsAlive() {
+		return nil, dk, commonStorage.KeyNotExistsError
+	}
+	return item, dk, nil
+}
+
+// findItem looks up key regardless of its type, which is what operations
+// like Set, Delete and Expire need since keys share a single namespace
+// across strings, hashes and lists.
+func (s *storage) findItem(txn *badger.Txn, key string) (*commonStorage.Item, []byte, error) {
+	for _, prefix := range typePrefixes {
+		if item, dk, err := s.getItem(txn, prefix, key); err == nil {
+			return item, dk, nil
+		}
+	}
+	return nil, nil, commonStorage.KeyNotExistsError
+}
+
+func (s *storage) saveItem(txn *badger.Txn, dataKey []byte, item *commonStorage.Item, oldExpireTime time.Time) error {
+	if !oldExpireTime.IsZero() {
+		if err := txn.Delete(ttlKey(oldExpireTime, dataKey)); err != nil {
+			return err
+		}
+	}
+
+	encoded, err := encodeItem(item)
+	if err != nil {
+		return err
+	}
+	if err := txn.Set(dataKey, encoded); err != nil {
+		return err
+	}
+
+	if !item.ExpireTime.IsZero() {
+		if err := txn.Set(ttlKey(item.ExpireTime, dataKey), dataKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *storage) deleteItem(txn *badger.Txn, dataKey []byte, item *commonStorage.Item) error {
+	if err := txn.Delete(dataKey); err != nil {
+		return err
+	}
+	if !item.ExpireTime.IsZero() {
+		return txn.Delete(ttlKey(item.ExpireTime, dataKey))
+	}
+	return nil
+}
+
+func (s *storage) getHash(txn *badger.Txn, key string) (commonStorage.Hash, error) {
+	item, _, err := s.getItem(txn, hashPrefix, key)
+	if err != nil {
+		return nil, err
+	}
+	return item.CastHash()
+}
+
+func (s *storage) getList(txn *badger.Txn, key string) (*commonStorage.List, error) {
+	item, _, err := s.getItem(txn, listPrefix, key)
+	if err != nil {
+		return nil, err
+	}
+	return item.CastList()
+}
+
+// Keys returns list of all keys
+func (s *storage) Keys() (keys []string) {
+	s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			badgerItem := it.Item()
+			key := badgerItem.KeyCopy(nil)
+			if len(key) == 0 || key[0] == ttlPrefix {
+				continue
+			}
+
+			raw, err := badgerItem.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			item, err := decodeItem(raw)
+			if err != nil {
+				return err
+			}
+			if item.IsAlive() {
+				keys = append(keys, string(key[1:]))
+			}
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return
+}
+
+// Expire sets new key ttl
+func (s *storage) Expire(key string, ttl uint64) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		item, dk, err := s.findItem(txn, key)
+		if err != nil {
+			return err
+		}
+
+		oldExpireTime := item.ExpireTime
+		item.SetTTL(ttl)
+		return s.saveItem(txn, dk, item, oldExpireTime)
+	})
+}
+
+// Get value of specified key. Error will occur if key doesn't exist or key type is not string.
+func (s *storage) Get(key string) (value string, err error) {
+	err = s.db.View(func(txn *badger.Txn) error {
+		item, _, err := s.getItem(txn, stringPrefix, key)
+		if err != nil {
+			return err
+		}
+		value, err = item.CastString()
+		return err
+	})
+	return
+}
+
+// Set value of specified key with ttl. Use zero ttl if key should exist forever.
+// Error will occur if key already exists.
+func (s *storage) Set(key, value string, ttl uint64) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		if _, _, err := s.findItem(txn, key); err == nil {
+			return commonStorage.KeyAlreadyExistsError
+		}
+
+		item := commonStorage.NewItem(value, ttl)
+		return s.saveItem(txn, dataKey(stringPrefix, key), item, time.Time{})
+	})
+}
+
+// Update value of specified key. Error will occur if key doesn't exist or key type is not string.
+func (s *storage) Update(key, value string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		item, dk, err := s.getItem(txn, stringPrefix, key)
+		if err != nil {
+			return err
+		}
+
+		item.Value = value
+		return s.saveItem(txn, dk, item, item.ExpireTime)
+	})
+}
+
+// Delete specified key. Error will occur if key doesn't exist. It works for any key type.
+func (s *storage) Delete(key string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		item, dk, err := s.findItem(txn, key)
+		if err != nil {
+			return err
+		}
+		return s.deleteItem(txn, dk, item)
+	})
+}
+
+// HashCreate creates new hash with specified key and ttl. Use zero ttl if key should exist forever.
+func (s *storage) HashCreate(key string, ttl uint64) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		if _, _, err := s.findItem(txn, key); err == nil {
+			return commonStorage.KeyAlreadyExistsError
+		}
+
+		item := commonStorage.NewItem(make(commonStorage.Hash), ttl)
+		return s.saveItem(txn, dataKey(hashPrefix, key), item, time.Time{})
+	})
+}
+
+// HashGet returns value of specified field of key.
+// Error will occur if key or field doesn't exist or key type is not hash.
+func (s *storage) HashGet(key, field string) (value string, err error) {
+	err = s.db.View(func(txn *badger.Txn) error {
+		hash, err := s.getHash(txn, key)
+		if err != nil {
+			return err
+		}
+		value, err = hash.GetValue(field)
+		return err
+	})
+	return
+}
+
+// HashGetAll returns all hash values of specified key. Error will occur if key doesn't exist or key type is not hash.
+func (s *storage) HashGetAll(key string) (hash map[string]string, err error) {
+	err = s.db.View(func(txn *badger.Txn) (err error) {
+		hash, err = s.getHash(txn, key)
+		return err
+	})
+	return
+}
+
+// HashSet sets field value of specified key. Error will occur if key doesn't exist or key type is not hash.
+func (s *storage) HashSet(key, field, value string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		item, dk, err := s.getItem(txn, hashPrefix, key)
+		if err != nil {
+			if _, _, err := s.findItem(txn, key); err == nil {
+				return commonStorage.KeyHashTypeError
+			}
+			item = commonStorage.NewItem(make(commonStorage.Hash), 0)
+			dk = dataKey(hashPrefix, key)
+		}
+		hash, err := item.CastHash()
+		if err != nil {
+			return err
+		}
+		hash[field] = value
+
+		return s.saveItem(txn, dk, item, item.ExpireTime)
+	})
+}
+
+// HashDelete deletes field from hash. Error will occur if key doesn't exist or key type is not hash.
+func (s *storage) HashDelete(key, field string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		item, dk, err := s.getItem(txn, hashPrefix, key)
+		if err != nil {
+			return err
+		}
+		hash, err := item.CastHash()
+		if err != nil {
+			return err
+		}
+		if _, err := hash.GetValue(field); err != nil {
+			return err
+		}
+		delete(hash, field)
+		return s.saveItem(txn, dk, item, item.ExpireTime)
+	})
+}
+
+// HashLen returns count of hash fields. Error will occur if key doesn't exist or key type is not hash.
+func (s *storage) HashLen(key string) (length int, err error) {
+	err = s.db.View(func(txn *badger.Txn) error {
+		hash, err := s.getHash(txn, key)
+		if err != nil {
+			return err
+		}
+		length = len(hash)
+		return nil
+	})
+	return
+}
+
+// HashKeys returns list of all hash fields. Error will occur if key doesn't exist or key type is not hash.
+func (s *storage) HashKeys(key string) (keys []string, err error) {
+	err = s.db.View(func(txn *badger.Txn) error {
+		hash, err := s.getHash(txn, key)
+		if err != nil {
+			return err
+		}
+		for key := range hash {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return nil
+	})
+	return
+}
+
+// ListCreate creates new list with specified key and ttl. Use zero duration if key should exist forever.
+func (s *storage) ListCreate(key string, ttl uint64) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		if _, _, err := s.findItem(txn, key); err == nil {
+			return commonStorage.KeyAlreadyExistsError
+		}
+
+		item := commonStorage.NewItem(commonStorage.NewList(), ttl)
+		return s.saveItem(txn, dataKey(listPrefix, key), item, time.Time{})
+	})
+}
+
+// ListLeftPop pops value from the list beginning.
+// Error will occur if key doesn't exist, key type is not list or list is empty.
+func (s *storage) ListLeftPop(key string) (value string, err error) {
+	err = s.db.Update(func(txn *badger.Txn) error {
+		item, dk, err := s.getItem(txn, listPrefix, key)
+		if err != nil {
+			return err
+		}
+		list, err := item.CastList()
+		if err != nil {
+			return err
+		}
+		value, err = list.LeftPop()
+		if err != nil {
+			return err
+		}
+		return s.saveItem(txn, dk, item, item.ExpireTime)
+	})
+	return
+}
+
+// ListRightPop pops value from the list ending.
+// Error will occur if key doesn't exist, key type is not list or list is empty.
+func (s *storage) ListRightPop(key string) (value string, err error) {
+	err = s.db.Update(func(txn *badger.Txn) error {
+		item, dk, err := s.getItem(txn, listPrefix, key)
+		if err != nil {
+			return err
+		}
+		list, err := item.CastList()
+		if err != nil {
+			return err
+		}
+		value, err = list.RightPop()
+		if err != nil {
+			return err
+		}
+		return s.saveItem(txn, dk, item, item.ExpireTime)
+	})
+	return
+}
+
+// ListLeftPush adds value to the list beginning. Error will occur if key doesn't exist or key type is not list.
+func (s *storage) ListLeftPush(key, value string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		item, dk, err := s.getItem(txn, listPrefix, key)
+		if err != nil {
+			return err
+		}
+		list, err := item.CastList()
+		if err != nil {
+			return err
+		}
+		list.LeftPush(value)
+		return s.saveItem(txn, dk, item, item.ExpireTime)
+	})
+}
+
+// ListRightPush adds value to the list ending. Error will occur if key doesn't exist or key type is not list.
+func (s *storage) ListRightPush(key, value string) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		item, dk, err := s.getItem(txn, listPrefix, key)
+		if err != nil {
+			return err
+		}
+		list, err := item.CastList()
+		if err != nil {
+			return err
+		}
+		list.RightPush(value)
+		return s.saveItem(txn, dk, item, item.ExpireTime)
+	})
+}
+
+// ListLen returns count of elements in the list. Error will occur if key doesn't exist or key type is not list.
+func (s *storage) ListLen(key string) (length int, err error) {
+	err = s.db.View(func(txn *badger.Txn) error {
+		list, err := s.getList(txn, key)
+		if err != nil {
+			return err
+		}
+		length = list.Len()
+		return nil
+	})
+	return
+}
+
+// ListRange returns list of elements from the list from start to stop index.
+// Error will occur if key doesn't exist or key type is not list.
+func (s *storage) ListRange(key string, start, stop int) (values []string, err error) {
+	err = s.db.View(func(txn *badger.Txn) error {
+		list, err := s.getList(txn, key)
+		if err != nil {
+			return err
+		}
+		values = list.Range(start, stop)
+		return nil
+	})
+	return
+}
+
+// Dump walks every live key under a single read-only transaction, so the
+// snapshot it produces is consistent without blocking concurrent reads or
+// writes, and calls fn with each one in turn. Keys are visited in
+// Badger's natural key order, which is stable across calls, so after (the
+// Cursor of a previously dumped Record) can be used to resume a dump that
+// was interrupted partway through.
+func (s *storage) Dump(after string, fn func(commonStorage.Record) error) error {
+	return s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		if after == "" {
+			it.Rewind()
+		} else {
+			it.Seek([]byte(after))
+			if it.Valid() && bytes.Equal(it.Item().KeyCopy(nil), []byte(after)) {
+				it.Next()
+			}
+		}
+
+		for ; it.Valid(); it.Next() {
+			badgerItem := it.Item()
+			key := badgerItem.KeyCopy(nil)
+			if len(key) == 0 || key[0] == ttlPrefix {
+				continue
+			}
+
+			raw, err := badgerItem.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			item, err := decodeItem(raw)
+			if err != nil {
+				return err
+			}
+			if !item.IsAlive() {
+				continue
+			}
+
+			record, err := dataKeyToRecord(key, item)
+			if err != nil {
+				return err
+			}
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Load writes record into storage, recreating whichever type it holds
+// under its original ttl. It does not check whether the key already
+// exists, since Loader is documented as only being safe to use against a
+// fresh, empty instance.
+func (s *storage) Load(record commonStorage.Record) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		prefix, value, err := recordValue(record)
+		if err != nil {
+			return err
+		}
+
+		item := commonStorage.NewItem(value, record.TTL)
+		return s.saveItem(txn, dataKey(prefix, record.Key), item, time.Time{})
+	})
+}
+
+// dataKeyToRecord turns a raw data key entry into the commonStorage.Record
+// Dump hands to its callback, using the entry's own key as the resume
+// cursor.
+func dataKeyToRecord(dk []byte, item *commonStorage.Item) (commonStorage.Record, error) {
+	record := commonStorage.Record{
+		Key:    string(dk[1:]),
+		TTL:    remainingTTL(item.ExpireTime),
+		Cursor: string(dk),
+	}
+
+	switch value := item.Value.(type) {
+	case string:
+		record.Type = commonStorage.StringRecord
+		record.String = value
+	case commonStorage.Hash:
+		record.Type = commonStorage.HashRecord
+		record.Hash = value
+	case *commonStorage.List:
+		record.Type = commonStorage.ListRecord
+		record.List = value.Items
+	default:
+		return commonStorage.Record{}, fmt.Errorf("unknown stored value type %T", item.Value)
+	}
+	return record, nil
+}
+
+// recordValue turns a commonStorage.Record back into the type prefix and
+// storage.Item value Load should save it under.
+func recordValue(record commonStorage.Record) (byte, interface{}, error) {
+	switch record.Type {
+	case commonStorage.StringRecord:
+		return stringPrefix, record.String, nil
+	case commonStorage.HashRecord:
+		return hashPrefix, commonStorage.Hash(record.Hash), nil
+	case commonStorage.ListRecord:
+		return listPrefix, &commonStorage.List{Items: record.List}, nil
+	default:
+		return 0, nil, fmt.Errorf("unknown record type %q", record.Type)
+	}
+}
+
+// remainingTTL converts an absolute expiry time into the seconds-remaining
+// form storage.Record carries, rounding up so a key that has not actually
+// expired yet is never rounded down to "never expires".
+func remainingTTL(expireTime time.Time) uint64 {
+	if expireTime.IsZero() {
+		return 0
+	}
+	remaining := time.Until(expireTime)
+	if remaining <= 0 {
+		return 0
+	}
+	return uint64(math.Ceil(remaining.Seconds()))
+}
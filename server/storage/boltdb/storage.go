@@ -2,9 +2,10 @@ package boltdb
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
-	"errors"
 	"fmt"
+	"math"
 	"sort"
 	"time"
 
@@ -12,137 +13,419 @@ import (
 	"github.com/boltdb/bolt"
 )
 
+// currentSchemaVersion is bumped whenever the on-disk bucket layout
+// changes in an incompatible way. Storage refuses to open a database
+// written by a newer version of itself.
+const currentSchemaVersion = 3
+
+// Key type prefixes let Keys() and the GC loop tell strings, hashes and
+// lists apart without gob-decoding every value.
+const (
+	stringPrefix = 's'
+	hashPrefix   = 'h'
+	listPrefix   = 'l'
+)
+
+var typePrefixes = []byte{stringPrefix, hashPrefix, listPrefix}
+
 var (
-	defaultBucketName = []byte("default")
-	notSupportedError = errors.New("Operation is not supported by BoltDB storage")
+	dataBucketName = []byte("data")
+	ttlBucketName  = []byte("ttl")
+	// expiryBucketName indexes dataKey -> expiration time, the reverse of
+	// ttlBucketName, so a caller holding a dataKey can check liveness
+	// without gob-decoding the item stored under it.
+	expiryBucketName = []byte("expiry")
+	metaBucketName   = []byte("meta")
+	schemaVersionKey = []byte("schema_version")
+	// legacyBucketName is the single bucket every key lived in before
+	// schema version 2 introduced type-prefixed keys and a ttl index.
+	legacyBucketName = []byte("default")
 )
 
 // Storage uses BoltDB as a persistent file-based storage.
 // encoding/gob is used to encode/decode data structures to put them into BoltDB.
-// Unfortunately container/list couldn't be used in a such way, so this storage doesn't support lists :(
-// It may be implemented by custom list solution or by using some different encoder/decoder.
+// Keys are stored in a single "data" bucket under a 1-byte type prefix, and
+// mirrored into a "ttl" bucket keyed by expiration time so the GC loop can
+// range over only the expired keys instead of decoding the whole dataset,
+// and into an "expiry" bucket keyed by dataKey so Keys() can check the same
+// liveness without decoding either.
 type storage struct {
-	db *bolt.DB
+	db     *bolt.DB
+	stopGC chan struct{}
 }
 
 func init() {
 	gob.Register(commonStorage.Item{})
 	gob.Register(commonStorage.Hash{})
+	gob.Register(&commonStorage.List{})
 }
 
+// openLockTimeout bounds how long Open waits for filePath's exclusive file
+// lock. BoltDB is single-writer: a second process opening the same file
+// (e.g. jcache-dump pointed at a running server's database) would
+// otherwise block forever waiting for a lock nothing will release; this
+// turns that into a prompt, actionable error instead.
+const openLockTimeout = 5 * time.Second
+
+// NewStorage opens filePath as a BoltDB-backed storage, migrating it from
+// the legacy single-bucket layout if necessary, and starts a background
+// goroutine that reaps expired keys every gcInterval.
 func NewStorage(filePath string, gcInterval time.Duration) (*storage, error) {
-	db, err := bolt.Open(filePath, 0644, nil)
+	db, err := bolt.Open(filePath, 0644, &bolt.Options{Timeout: openLockTimeout})
 	if err != nil {
 		return nil, fmt.Errorf("Cannot open Bolt file: %s", err)
 	}
-	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(defaultBucketName)
-		return err
-	})
-	if err != nil {
-		return nil, fmt.Errorf("Cannot create bucket: %s", err)
+
+	if err := migrate(db); err != nil {
+		return nil, fmt.Errorf("Cannot migrate Bolt file: %s", err)
 	}
 
-	s := &storage{db: db}
+	s := &storage{db: db, stopGC: make(chan struct{})}
 	go s.gc(gcInterval)
 
 	return s, nil
 }
 
-func (s *storage) gc(interval time.Duration) {
-	for _ = range time.Tick(interval) {
-		deleteKeys := [][]byte{}
-		err := s.db.View(func(tx *bolt.Tx) error {
-			bucket := tx.Bucket(defaultBucketName)
-			cursor := bucket.Cursor()
-			for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
-				dec := gob.NewDecoder(bytes.NewBuffer(value))
-				item := &commonStorage.Item{}
-				err := dec.Decode(item)
-				if err != nil {
+// migrate ensures the database is on currentSchemaVersion, rewriting the
+// legacy single-bucket layout into the type-prefixed layout inside a
+// single transaction if one is found, and backfilling the expiry bucket
+// for a version 2 database that predates it.
+func migrate(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucketName)
+		if err != nil {
+			return err
+		}
+		data, err := tx.CreateBucketIfNotExists(dataBucketName)
+		if err != nil {
+			return err
+		}
+		ttl, err := tx.CreateBucketIfNotExists(ttlBucketName)
+		if err != nil {
+			return err
+		}
+		expiry, err := tx.CreateBucketIfNotExists(expiryBucketName)
+		if err != nil {
+			return err
+		}
+
+		if version := meta.Get(schemaVersionKey); version != nil {
+			switch {
+			case decodeVersion(version) > currentSchemaVersion:
+				return fmt.Errorf("database schema version %d is newer than supported version %d", decodeVersion(version), currentSchemaVersion)
+			case decodeVersion(version) == currentSchemaVersion:
+				return nil
+			default:
+				// Only version 2 predates this version, and it has
+				// everything except the expiry bucket.
+				if err := backfillExpiryIndex(ttl, expiry); err != nil {
 					return err
 				}
+				return meta.Put(schemaVersionKey, encodeVersion(currentSchemaVersion))
+			}
+		}
 
-				if !item.IsAlive() {
-					deleteKeys = append(deleteKeys, key)
-				}
+		if legacy := tx.Bucket(legacyBucketName); legacy != nil {
+			if err := migrateLegacyBucket(legacy, data, ttl, expiry); err != nil {
+				return err
+			}
+			if err := tx.DeleteBucket(legacyBucketName); err != nil {
+				return err
+			}
+		}
+
+		return meta.Put(schemaVersionKey, encodeVersion(currentSchemaVersion))
+	})
+}
+
+func migrateLegacyBucket(legacy, data, ttl, expiry *bolt.Bucket) error {
+	cursor := legacy.Cursor()
+	for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+		item, err := decodeItem(value)
+		if err != nil {
+			return err
+		}
+
+		prefix, err := typePrefix(item)
+		if err != nil {
+			return err
+		}
+
+		dk := dataKey(prefix, string(key))
+		if err := data.Put(dk, value); err != nil {
+			return err
+		}
+		if !item.ExpireTime.IsZero() {
+			if err := ttl.Put(ttlKey(item.ExpireTime, dk), dk); err != nil {
+				return err
+			}
+			if err := expiry.Put(dk, encodeExpireTime(item.ExpireTime)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// backfillExpiryIndex rebuilds the expiry bucket (dataKey -> expiration
+// time) from the ttl bucket (expiration time -> dataKey) for a database
+// migrating from schema version 2, which had no reverse index.
+func backfillExpiryIndex(ttl, expiry *bolt.Bucket) error {
+	cursor := ttl.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		if err := expiry.Put(append([]byte{}, v...), k[:8]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func typePrefix(item *commonStorage.Item) (byte, error) {
+	switch item.Value.(type) {
+	case string:
+		return stringPrefix, nil
+	case commonStorage.Hash:
+		return hashPrefix, nil
+	case *commonStorage.List:
+		return listPrefix, nil
+	default:
+		return 0, fmt.Errorf("unknown stored value type %T", item.Value)
+	}
+}
+
+func encodeVersion(version uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, version)
+	return buf
+}
+
+func decodeVersion(data []byte) uint32 {
+	return binary.BigEndian.Uint32(data)
+}
+
+// dataKey builds the key a value of the given type prefix is stored under
+// in the data bucket.
+func dataKey(prefix byte, key string) []byte {
+	buf := make([]byte, 1+len(key))
+	buf[0] = prefix
+	copy(buf[1:], key)
+	return buf
+}
+
+// encodeExpireTime is the 8-byte big-endian encoding of an expiration time
+// shared by ttlKey and the expiry bucket, chosen so byte comparison sorts
+// the same as time comparison.
+func encodeExpireTime(expireTime time.Time) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(expireTime.UnixNano()))
+	return buf
+}
+
+func decodeExpireTime(data []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+}
+
+// ttlKey builds a key for the ttl bucket that sorts by expiration time
+// first, so the GC loop can cursor-walk only the expired prefix. dataKey
+// is appended so distinct keys expiring at the same instant don't collide.
+func ttlKey(expireTime time.Time, dataKey []byte) []byte {
+	buf := make([]byte, 8+len(dataKey))
+	copy(buf, encodeExpireTime(expireTime))
+	copy(buf[8:], dataKey)
+	return buf
+}
+
+func (s *storage) gc(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopGC:
+			return
+		case <-ticker.C:
+		}
+
+		boundary := ttlKey(time.Now(), nil)
+
+		var expiredTTLKeys, expiredDataKeys [][]byte
+		s.db.View(func(tx *bolt.Tx) error {
+			cursor := tx.Bucket(ttlBucketName).Cursor()
+			for k, v := cursor.First(); k != nil && bytes.Compare(k, boundary) < 0; k, v = cursor.Next() {
+				expiredTTLKeys = append(expiredTTLKeys, append([]byte{}, k...))
+				expiredDataKeys = append(expiredDataKeys, append([]byte{}, v...))
 			}
 			return nil
 		})
-		if err == nil && len(deleteKeys) > 0 {
-			s.db.Update(func(tx *bolt.Tx) error {
-				bucket := tx.Bucket(defaultBucketName)
-				for _, key := range deleteKeys {
-					bucket.Delete(key)
-				}
-				return nil
-			})
+
+		if len(expiredTTLKeys) == 0 {
+			continue
 		}
+
+		s.db.Update(func(tx *bolt.Tx) error {
+			ttl := tx.Bucket(ttlBucketName)
+			data := tx.Bucket(dataBucketName)
+			expiry := tx.Bucket(expiryBucketName)
+			for i := range expiredTTLKeys {
+				ttl.Delete(expiredTTLKeys[i])
+				data.Delete(expiredDataKeys[i])
+				expiry.Delete(expiredDataKeys[i])
+			}
+			return nil
+		})
 	}
 }
 
-func (s *storage) getItem(bucket *bolt.Bucket, key string) (*commonStorage.Item, error) {
-	data := bucket.Get([]byte(key))
+// StopGC stops the background GC goroutine started by NewStorage. It is
+// used by a cluster wrapping this storage to disable independent,
+// wall-clock-driven expiry in favor of leader-driven, replicated expiry
+// via ExpiringKeys. Calling it more than once panics, same as closing
+// any other channel twice.
+func (s *storage) StopGC() {
+	close(s.stopGC)
+}
+
+// ExpiringKeys returns every live key whose expiration time is at or
+// before cutoff, without deleting them. A cluster leader uses this to
+// discover expired keys and propose their removal through Raft so every
+// node deletes them at the same point in the replicated log instead of
+// each node's own GC racing to expire the same key independently.
+func (s *storage) ExpiringKeys(cutoff time.Time) ([]string, error) {
+	boundary := ttlKey(cutoff.Add(time.Nanosecond), nil)
+
+	var keys []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(ttlBucketName).Cursor()
+		for k, v := cursor.First(); k != nil && bytes.Compare(k, boundary) < 0; k, v = cursor.Next() {
+			if len(v) < 1 {
+				continue
+			}
+			keys = append(keys, string(v[1:]))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func decodeItem(data []byte) (*commonStorage.Item, error) {
+	var item commonStorage.Item
+	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func encodeItem(item *commonStorage.Item) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(item); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// getItem looks up the item stored under key with the given type prefix.
+// It also returns the data bucket key it was found at, so callers can
+// pass it straight to saveItem/deleteItem.
+func (s *storage) getItem(bucket *bolt.Bucket, prefix byte, key string) (*commonStorage.Item, []byte, error) {
+	dk := dataKey(prefix, key)
+	data := bucket.Get(dk)
 	if data == nil {
-		return nil, commonStorage.KeyNotExistsError
+		return nil, dk, commonStorage.KeyNotExistsError
 	}
 
-	dec := gob.NewDecoder(bytes.NewBuffer(data))
-	var item commonStorage.Item
-	err := dec.Decode(&item)
+	item, err := decodeItem(data)
 	if err != nil {
-		return nil, err
+		return nil, dk, err
+	}
+	if !item.IsAlive() {
+		return nil, dk, commonStorage.KeyNotExistsError
 	}
+	return item, dk, nil
+}
 
-	if item.IsAlive() {
-		return &item, nil
-	} else {
-		return nil, commonStorage.KeyNotExistsError
+// findItem looks up key regardless of its type, which is what operations
+// like Set, Delete and Expire need since keys share a single namespace
+// across strings, hashes and lists.
+func (s *storage) findItem(bucket *bolt.Bucket, key string) (*commonStorage.Item, []byte, error) {
+	for _, prefix := range typePrefixes {
+		if item, dk, err := s.getItem(bucket, prefix, key); err == nil {
+			return item, dk, nil
+		}
 	}
+	return nil, nil, commonStorage.KeyNotExistsError
 }
 
-func (s *storage) saveItem(bucket *bolt.Bucket, key string, item *commonStorage.Item) error {
-	buf := &bytes.Buffer{}
-	enc := gob.NewEncoder(buf)
-	err := enc.Encode(item)
+// saveItem writes item under dataKey and keeps the ttl and expiry buckets
+// in sync, removing oldExpireTime's entry (if any) and adding item's
+// current one.
+func (s *storage) saveItem(tx *bolt.Tx, dataKey []byte, item *commonStorage.Item, oldExpireTime time.Time) error {
+	ttl := tx.Bucket(ttlBucketName)
+	expiry := tx.Bucket(expiryBucketName)
+	if !oldExpireTime.IsZero() {
+		if err := ttl.Delete(ttlKey(oldExpireTime, dataKey)); err != nil {
+			return err
+		}
+	}
+
+	encoded, err := encodeItem(item)
 	if err != nil {
 		return err
 	}
-	err = bucket.Put([]byte(key), buf.Bytes())
-	if err != nil {
+	if err := tx.Bucket(dataBucketName).Put(dataKey, encoded); err != nil {
 		return err
 	}
+
+	if item.ExpireTime.IsZero() {
+		return expiry.Delete(dataKey)
+	}
+	if err := ttl.Put(ttlKey(item.ExpireTime, dataKey), dataKey); err != nil {
+		return err
+	}
+	return expiry.Put(dataKey, encodeExpireTime(item.ExpireTime))
+}
+
+func (s *storage) deleteItem(tx *bolt.Tx, dataKey []byte, item *commonStorage.Item) error {
+	if err := tx.Bucket(dataBucketName).Delete(dataKey); err != nil {
+		return err
+	}
+	if err := tx.Bucket(expiryBucketName).Delete(dataKey); err != nil {
+		return err
+	}
+	if !item.ExpireTime.IsZero() {
+		return tx.Bucket(ttlBucketName).Delete(ttlKey(item.ExpireTime, dataKey))
+	}
 	return nil
 }
 
 func (s *storage) getHash(bucket *bolt.Bucket, key string) (commonStorage.Hash, error) {
-	item, err := s.getItem(bucket, key)
+	item, _, err := s.getItem(bucket, hashPrefix, key)
 	if err != nil {
 		return nil, err
 	}
-	hash, err := item.CastHash()
+	return item.CastHash()
+}
+
+func (s *storage) getList(bucket *bolt.Bucket, key string) (*commonStorage.List, error) {
+	item, _, err := s.getItem(bucket, listPrefix, key)
 	if err != nil {
 		return nil, err
 	}
-	return hash, nil
+	return item.CastList()
 }
 
-// Keys returns list of all keys
+// Keys returns list of all keys. Liveness is checked against the expiry
+// bucket's dataKey->expiration reverse index instead of gob-decoding every
+// value, so this cursor-walks the data bucket without deserializing a
+// single Item.
 func (s *storage) Keys() (keys []string) {
 	s.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(defaultBucketName)
-		cursor := bucket.Cursor()
-		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
-			dec := gob.NewDecoder(bytes.NewBuffer(value))
-			item := &commonStorage.Item{}
-			err := dec.Decode(item)
-			if err != nil {
-				return err
-			}
-
-			if item.IsAlive() {
-				keys = append(keys, string(key))
+		expiry := tx.Bucket(expiryBucketName)
+		cursor := tx.Bucket(dataBucketName).Cursor()
+		for key, _ := cursor.First(); key != nil; key, _ = cursor.Next() {
+			if expireTime := expiry.Get(key); expireTime != nil && !decodeExpireTime(expireTime).After(time.Now()) {
+				continue
 			}
+			keys = append(keys, string(key[1:]))
 		}
 		return nil
 	})
@@ -153,22 +436,21 @@ func (s *storage) Keys() (keys []string) {
 // Expire sets new key ttl
 func (s *storage) Expire(key string, ttl uint64) error {
 	return s.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(defaultBucketName)
-		item, err := s.getItem(bucket, key)
+		item, dk, err := s.findItem(tx.Bucket(dataBucketName), key)
 		if err != nil {
 			return err
 		}
 
+		oldExpireTime := item.ExpireTime
 		item.SetTTL(ttl)
-		return s.saveItem(bucket, key, item)
+		return s.saveItem(tx, dk, item, oldExpireTime)
 	})
 }
 
 // Get value of specified key. Error will occur if key doesn't exist or key type is not string.
 func (s *storage) Get(key string) (value string, err error) {
 	err = s.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(defaultBucketName)
-		item, err := s.getItem(bucket, key)
+		item, _, err := s.getItem(tx.Bucket(dataBucketName), stringPrefix, key)
 		if err != nil {
 			return err
 		}
@@ -182,54 +464,50 @@ func (s *storage) Get(key string) (value string, err error) {
 // Error will occur if key already exists.
 func (s *storage) Set(key, value string, ttl uint64) error {
 	return s.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(defaultBucketName)
-		item, _ := s.getItem(bucket, key)
-		if item != nil {
+		bucket := tx.Bucket(dataBucketName)
+		if _, _, err := s.findItem(bucket, key); err == nil {
 			return commonStorage.KeyAlreadyExistsError
 		}
 
-		item = commonStorage.NewItem(value, ttl)
-		return s.saveItem(bucket, key, item)
+		item := commonStorage.NewItem(value, ttl)
+		return s.saveItem(tx, dataKey(stringPrefix, key), item, time.Time{})
 	})
 }
 
 // Update value of specified key. Error will occur if key doesn't exist or key type is not string.
 func (s *storage) Update(key, value string) error {
 	return s.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(defaultBucketName)
-		item, err := s.getItem(bucket, key)
+		item, dk, err := s.getItem(tx.Bucket(dataBucketName), stringPrefix, key)
 		if err != nil {
 			return err
 		}
 
 		item.Value = value
-		return s.saveItem(bucket, key, item)
+		return s.saveItem(tx, dk, item, item.ExpireTime)
 	})
 }
 
 // Delete specified key. Error will occur if key doesn't exist. It works for any key type.
 func (s *storage) Delete(key string) error {
 	return s.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(defaultBucketName)
-		_, err := s.getItem(bucket, key)
+		item, dk, err := s.findItem(tx.Bucket(dataBucketName), key)
 		if err != nil {
 			return err
 		}
-		return bucket.Delete([]byte(key))
+		return s.deleteItem(tx, dk, item)
 	})
 }
 
 // HashCreate creates new hash with specified key and ttl. Use zero ttl if key should exist forever.
 func (s *storage) HashCreate(key string, ttl uint64) error {
 	return s.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(defaultBucketName)
-		item, _ := s.getItem(bucket, key)
-		if item != nil {
+		bucket := tx.Bucket(dataBucketName)
+		if _, _, err := s.findItem(bucket, key); err == nil {
 			return commonStorage.KeyAlreadyExistsError
 		}
 
-		item = commonStorage.NewItem(make(commonStorage.Hash), ttl)
-		return s.saveItem(bucket, key, item)
+		item := commonStorage.NewItem(make(commonStorage.Hash), ttl)
+		return s.saveItem(tx, dataKey(hashPrefix, key), item, time.Time{})
 	})
 }
 
@@ -237,8 +515,7 @@ func (s *storage) HashCreate(key string, ttl uint64) error {
 // Error will occur if key or field doesn't exist or key type is not hash.
 func (s *storage) HashGet(key, field string) (value string, err error) {
 	err = s.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(defaultBucketName)
-		hash, err := s.getHash(bucket, key)
+		hash, err := s.getHash(tx.Bucket(dataBucketName), key)
 		if err != nil {
 			return err
 		}
@@ -251,8 +528,7 @@ func (s *storage) HashGet(key, field string) (value string, err error) {
 // HashGetAll returns all hash values of specified key. Error will occur if key doesn't exist or key type is not hash.
 func (s *storage) HashGetAll(key string) (hash map[string]string, err error) {
 	err = s.db.View(func(tx *bolt.Tx) (err error) {
-		bucket := tx.Bucket(defaultBucketName)
-		hash, err = s.getHash(bucket, key)
+		hash, err = s.getHash(tx.Bucket(dataBucketName), key)
 		return err
 	})
 	return
@@ -261,10 +537,14 @@ func (s *storage) HashGetAll(key string) (hash map[string]string, err error) {
 // HashSet sets field value of specified key. Error will occur if key doesn't exist or key type is not hash.
 func (s *storage) HashSet(key, field, value string) error {
 	return s.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(defaultBucketName)
-		item, err := s.getItem(bucket, key)
+		bucket := tx.Bucket(dataBucketName)
+		item, dk, err := s.getItem(bucket, hashPrefix, key)
 		if err != nil {
+			if _, _, err := s.findItem(bucket, key); err == nil {
+				return commonStorage.KeyHashTypeError
+			}
 			item = commonStorage.NewItem(make(commonStorage.Hash), 0)
+			dk = dataKey(hashPrefix, key)
 		}
 		hash, err := item.CastHash()
 		if err != nil {
@@ -272,15 +552,15 @@ func (s *storage) HashSet(key, field, value string) error {
 		}
 		hash[field] = value
 
-		return s.saveItem(bucket, key, item)
+		return s.saveItem(tx, dk, item, item.ExpireTime)
 	})
 }
 
 // HashDelete deletes field from hash. Error will occur if key doesn't exist or key type is not hash.
 func (s *storage) HashDelete(key, field string) error {
 	return s.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(defaultBucketName)
-		item, err := s.getItem(bucket, key)
+		bucket := tx.Bucket(dataBucketName)
+		item, dk, err := s.getItem(bucket, hashPrefix, key)
 		if err != nil {
 			return err
 		}
@@ -288,20 +568,18 @@ func (s *storage) HashDelete(key, field string) error {
 		if err != nil {
 			return err
 		}
-		_, err = hash.GetValue(field)
-		if err != nil {
+		if _, err := hash.GetValue(field); err != nil {
 			return err
 		}
 		delete(hash, field)
-		return s.saveItem(bucket, key, item)
+		return s.saveItem(tx, dk, item, item.ExpireTime)
 	})
 }
 
 // HashLen returns count of hash fields. Error will occur if key doesn't exist or key type is not hash.
 func (s *storage) HashLen(key string) (length int, err error) {
 	err = s.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(defaultBucketName)
-		hash, err := s.getHash(bucket, key)
+		hash, err := s.getHash(tx.Bucket(dataBucketName), key)
 		if err != nil {
 			return err
 		}
@@ -314,8 +592,7 @@ func (s *storage) HashLen(key string) (length int, err error) {
 // HashKeys returns list of all hash fields. Error will occur if key doesn't exist or key type is not hash.
 func (s *storage) HashKeys(key string) (keys []string, err error) {
 	err = s.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(defaultBucketName)
-		hash, err := s.getHash(bucket, key)
+		hash, err := s.getHash(tx.Bucket(dataBucketName), key)
 		if err != nil {
 			return err
 		}
@@ -330,38 +607,225 @@ func (s *storage) HashKeys(key string) (keys []string, err error) {
 
 // ListCreate creates new list with specified key and ttl. Use zero duration if key should exist forever.
 func (s *storage) ListCreate(key string, ttl uint64) error {
-	return notSupportedError
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(dataBucketName)
+		if _, _, err := s.findItem(bucket, key); err == nil {
+			return commonStorage.KeyAlreadyExistsError
+		}
+
+		item := commonStorage.NewItem(commonStorage.NewList(), ttl)
+		return s.saveItem(tx, dataKey(listPrefix, key), item, time.Time{})
+	})
 }
 
 // ListLeftPop pops value from the list beginning.
 // Error will occur if key doesn't exist, key type is not list or list is empty.
 func (s *storage) ListLeftPop(key string) (value string, err error) {
-	return "", notSupportedError
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		item, dk, err := s.getItem(tx.Bucket(dataBucketName), listPrefix, key)
+		if err != nil {
+			return err
+		}
+		list, err := item.CastList()
+		if err != nil {
+			return err
+		}
+		value, err = list.LeftPop()
+		if err != nil {
+			return err
+		}
+		return s.saveItem(tx, dk, item, item.ExpireTime)
+	})
+	return
 }
 
 // ListRightPop pops value from the list ending.
 // Error will occur if key doesn't exist, key type is not list or list is empty.
 func (s *storage) ListRightPop(key string) (value string, err error) {
-	return "", notSupportedError
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		item, dk, err := s.getItem(tx.Bucket(dataBucketName), listPrefix, key)
+		if err != nil {
+			return err
+		}
+		list, err := item.CastList()
+		if err != nil {
+			return err
+		}
+		value, err = list.RightPop()
+		if err != nil {
+			return err
+		}
+		return s.saveItem(tx, dk, item, item.ExpireTime)
+	})
+	return
 }
 
 // ListLeftPush adds value to the list beginning. Error will occur if key doesn't exist or key type is not list.
 func (s *storage) ListLeftPush(key, value string) error {
-	return notSupportedError
+	return s.db.Update(func(tx *bolt.Tx) error {
+		item, dk, err := s.getItem(tx.Bucket(dataBucketName), listPrefix, key)
+		if err != nil {
+			return err
+		}
+		list, err := item.CastList()
+		if err != nil {
+			return err
+		}
+		list.LeftPush(value)
+		return s.saveItem(tx, dk, item, item.ExpireTime)
+	})
 }
 
 // ListRightPush adds value to the list ending. Error will occur if key doesn't exist or key type is not list.
 func (s *storage) ListRightPush(key, value string) error {
-	return notSupportedError
+	return s.db.Update(func(tx *bolt.Tx) error {
+		item, dk, err := s.getItem(tx.Bucket(dataBucketName), listPrefix, key)
+		if err != nil {
+			return err
+		}
+		list, err := item.CastList()
+		if err != nil {
+			return err
+		}
+		list.RightPush(value)
+		return s.saveItem(tx, dk, item, item.ExpireTime)
+	})
 }
 
 // ListLen returns count of elements in the list. Error will occur if key doesn't exist or key type is not list.
 func (s *storage) ListLen(key string) (length int, err error) {
-	return 0, notSupportedError
+	err = s.db.View(func(tx *bolt.Tx) error {
+		list, err := s.getList(tx.Bucket(dataBucketName), key)
+		if err != nil {
+			return err
+		}
+		length = list.Len()
+		return nil
+	})
+	return
 }
 
 // ListRange returns list of elements from the list from start to stop index.
 // Error will occur if key doesn't exist or key type is not list.
 func (s *storage) ListRange(key string, start, stop int) (values []string, err error) {
-	return nil, notSupportedError
+	err = s.db.View(func(tx *bolt.Tx) error {
+		list, err := s.getList(tx.Bucket(dataBucketName), key)
+		if err != nil {
+			return err
+		}
+		values = list.Range(start, stop)
+		return nil
+	})
+	return
+}
+
+// Dump walks every live key under a single db.View, so the snapshot it
+// produces is consistent without blocking concurrent reads or writes, and
+// calls fn with each one in turn. Keys are visited in data bucket cursor
+// order, which is stable across calls, so after (the Cursor of a
+// previously dumped Record) can be used to resume a dump that was
+// interrupted partway through.
+func (s *storage) Dump(after string, fn func(commonStorage.Record) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(dataBucketName).Cursor()
+
+		var key, value []byte
+		if after == "" {
+			key, value = cursor.First()
+		} else {
+			key, value = cursor.Seek([]byte(after))
+			if key != nil && bytes.Equal(key, []byte(after)) {
+				key, value = cursor.Next()
+			}
+		}
+
+		for ; key != nil; key, value = cursor.Next() {
+			item, err := decodeItem(value)
+			if err != nil {
+				return err
+			}
+			if !item.IsAlive() {
+				continue
+			}
+
+			record, err := dataKeyToRecord(key, item)
+			if err != nil {
+				return err
+			}
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Load writes record into storage, recreating whichever type it holds
+// under its original ttl. It does not check whether the key already
+// exists, since Loader is documented as only being safe to use against a
+// fresh, empty instance.
+func (s *storage) Load(record commonStorage.Record) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		prefix, value, err := recordValue(record)
+		if err != nil {
+			return err
+		}
+
+		item := commonStorage.NewItem(value, record.TTL)
+		return s.saveItem(tx, dataKey(prefix, record.Key), item, time.Time{})
+	})
+}
+
+// dataKeyToRecord turns a raw data bucket entry into the commonStorage.Record
+// Dump hands to its callback, using the entry's own key as the resume cursor.
+func dataKeyToRecord(dk []byte, item *commonStorage.Item) (commonStorage.Record, error) {
+	record := commonStorage.Record{
+		Key:    string(dk[1:]),
+		TTL:    remainingTTL(item.ExpireTime),
+		Cursor: string(dk),
+	}
+
+	switch value := item.Value.(type) {
+	case string:
+		record.Type = commonStorage.StringRecord
+		record.String = value
+	case commonStorage.Hash:
+		record.Type = commonStorage.HashRecord
+		record.Hash = value
+	case *commonStorage.List:
+		record.Type = commonStorage.ListRecord
+		record.List = value.Items
+	default:
+		return commonStorage.Record{}, fmt.Errorf("unknown stored value type %T", item.Value)
+	}
+	return record, nil
+}
+
+// recordValue turns a commonStorage.Record back into the type prefix and
+// storage.Item value Load should save it under.
+func recordValue(record commonStorage.Record) (byte, interface{}, error) {
+	switch record.Type {
+	case commonStorage.StringRecord:
+		return stringPrefix, record.String, nil
+	case commonStorage.HashRecord:
+		return hashPrefix, commonStorage.Hash(record.Hash), nil
+	case commonStorage.ListRecord:
+		return listPrefix, &commonStorage.List{Items: record.List}, nil
+	default:
+		return 0, nil, fmt.Errorf("unknown record type %q", record.Type)
+	}
+}
+
+// remainingTTL converts an absolute expiry time into the seconds-remaining
+// form storage.Record carries, rounding up so a key that has not actually
+// expired yet is never rounded down to "never expires".
+func remainingTTL(expireTime time.Time) uint64 {
+	if expireTime.IsZero() {
+		return 0
+	}
+	remaining := time.Until(expireTime)
+	if remaining <= 0 {
+		return 0
+	}
+	return uint64(math.Ceil(remaining.Seconds()))
 }
@@ -0,0 +1,35 @@
+package boltdb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	commonStorage "github.com/Barberrrry/jcache/server/storage"
+)
+
+func TestListTTLExpiryMidList(t *testing.T) {
+	s, err := NewStorage(filepath.Join(t.TempDir(), "jcache.db"), time.Hour)
+	if err != nil {
+		t.Fatalf("NewStorage() error = %v", err)
+	}
+
+	if err := s.ListCreate("mylist", 1); err != nil {
+		t.Fatalf("ListCreate() error = %v", err)
+	}
+	if err := s.ListRightPush("mylist", "a"); err != nil {
+		t.Fatalf("ListRightPush() error = %v", err)
+	}
+	if err := s.ListRightPush("mylist", "b"); err != nil {
+		t.Fatalf("ListRightPush() error = %v", err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	if _, err := s.ListLeftPop("mylist"); err != commonStorage.KeyNotExistsError {
+		t.Errorf("ListLeftPop() after ttl expiry error = %v, want %v", err, commonStorage.KeyNotExistsError)
+	}
+	if _, err := s.ListLen("mylist"); err != commonStorage.KeyNotExistsError {
+		t.Errorf("ListLen() after ttl expiry error = %v, want %v", err, commonStorage.KeyNotExistsError)
+	}
+}
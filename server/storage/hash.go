@@ -0,0 +1,14 @@
+package storage
+
+// Hash is a gob-friendly set of field/value pairs backing the HASH family
+// of commands.
+type Hash map[string]string
+
+// GetValue returns the value of field, or FieldNotExistError if the field
+// does not exist in the hash.
+func (h Hash) GetValue(field string) (string, error) {
+	if value, ok := h[field]; ok {
+		return value, nil
+	}
+	return "", FieldNotExistError
+}
@@ -1,7 +1,6 @@
 package storage
 
 import (
-	"container/list"
 	"time"
 )
 
@@ -33,8 +32,8 @@ func (i *Item) CastHash() (Hash, error) {
 	}
 }
 
-func (i *Item) CastList() (*list.List, error) {
-	if list, ok := i.Value.(*list.List); ok {
+func (i *Item) CastList() (*List, error) {
+	if list, ok := i.Value.(*List); ok {
 		return list, nil
 	} else {
 		return nil, KeyListTypeError
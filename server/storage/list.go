@@ -0,0 +1,81 @@
+package storage
+
+// List is a gob-friendly ordered collection of string values.
+// container/list.List cannot be round-tripped through encoding/gob, so
+// list keys are stored as a plain slice instead.
+type List struct {
+	Items []string
+}
+
+// NewList creates an empty list.
+func NewList() *List {
+	return &List{}
+}
+
+// LeftPop removes and returns the first element of the list.
+func (l *List) LeftPop() (string, error) {
+	if len(l.Items) == 0 {
+		return "", ListEmptyError
+	}
+	value := l.Items[0]
+	l.Items = l.Items[1:]
+	return value, nil
+}
+
+// RightPop removes and returns the last element of the list.
+func (l *List) RightPop() (string, error) {
+	if len(l.Items) == 0 {
+		return "", ListEmptyError
+	}
+	last := len(l.Items) - 1
+	value := l.Items[last]
+	l.Items = l.Items[:last]
+	return value, nil
+}
+
+// LeftPush inserts value at the beginning of the list.
+func (l *List) LeftPush(value string) {
+	l.Items = append([]string{value}, l.Items...)
+}
+
+// RightPush appends value to the end of the list.
+func (l *List) RightPush(value string) {
+	l.Items = append(l.Items, value)
+}
+
+// Len returns the number of elements in the list.
+func (l *List) Len() int {
+	return len(l.Items)
+}
+
+// Range returns elements between start and stop indexes inclusive.
+// Negative indexes count from the end of the list, and out of range
+// indexes are clipped rather than causing an error.
+func (l *List) Range(start, stop int) []string {
+	length := len(l.Items)
+	if length == 0 {
+		return []string{}
+	}
+
+	start = normalizeListIndex(start, length)
+	stop = normalizeListIndex(stop, length)
+
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop || start >= length {
+		return []string{}
+	}
+
+	return l.Items[start : stop+1]
+}
+
+func normalizeListIndex(index, length int) int {
+	if index < 0 {
+		index += length
+		if index < 0 {
+			index = 0
+		}
+	}
+	return index
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestListPopFromEmpty(t *testing.T) {
+	l := NewList()
+
+	if _, err := l.LeftPop(); err != ListEmptyError {
+		t.Errorf("LeftPop() error = %v, want %v", err, ListEmptyError)
+	}
+	if _, err := l.RightPop(); err != ListEmptyError {
+		t.Errorf("RightPop() error = %v, want %v", err, ListEmptyError)
+	}
+}
+
+func TestListRangeClipping(t *testing.T) {
+	cases := []struct {
+		name        string
+		items       []string
+		start, stop int
+		want        []string
+	}{
+		{
+			name:  "empty list",
+			items: nil,
+			start: 0, stop: -1,
+			want: []string{},
+		},
+		{
+			name:  "full range via negative indexes",
+			items: []string{"a", "b", "c"},
+			start: 0, stop: -1,
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name:  "stop clipped to list end",
+			items: []string{"a", "b", "c"},
+			start: 1, stop: 100,
+			want: []string{"b", "c"},
+		},
+		{
+			name:  "start clipped to list start",
+			items: []string{"a", "b", "c"},
+			start: -100, stop: 1,
+			want: []string{"a", "b"},
+		},
+		{
+			name:  "start past stop returns empty",
+			items: []string{"a", "b", "c"},
+			start: 2, stop: 0,
+			want: []string{},
+		},
+		{
+			name:  "start at or past list length returns empty",
+			items: []string{"a", "b", "c"},
+			start: 3, stop: 5,
+			want: []string{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			l := &List{Items: c.items}
+			got := l.Range(c.start, c.stop)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("Range(%d, %d) = %v, want %v", c.start, c.stop, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,702 @@
+// Package pebble implements storage.Storage on top of CockroachDB's
+// Pebble engine, sharing the same type-prefixed key layout and ttl-index
+// GC pattern as the boltdb and badger backends.
+package pebble
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+
+	commonStorage "github.com/Barberrrry/jcache/server/storage"
+)
+
+const (
+	stringPrefix = 's'
+	hashPrefix   = 'h'
+	listPrefix   = 'l'
+	ttlPrefix    = 't'
+)
+
+var typePrefixes = []byte{stringPrefix, hashPrefix, listPrefix}
+
+// Storage uses Pebble as a persistent file-based storage. Reads and
+// writes within a call go through an indexed batch, which gives a
+// get-modify-put sequence a consistent view of its own uncommitted writes;
+// unlike bolt's single-writer transactions or badger's SSI conflict
+// checking, Pebble's batches do not detect concurrent writers racing each
+// other, so update also takes mu to serialize them itself. encoding/gob
+// turns the data structures into bytes the same way the boltdb backend
+// does.
+type storage struct {
+	db     *pebble.DB
+	mu     sync.Mutex
+	stopGC chan struct{}
+}
+
+func init() {
+	gob.Register(commonStorage.Item{})
+	gob.Register(commonStorage.Hash{})
+	gob.Register(&commonStorage.List{})
+}
+
+// NewStorage opens dir as a Pebble-backed storage and starts a background
+// goroutine that reaps expired keys every gcInterval.
+func NewStorage(dir string, gcInterval time.Duration) (*storage, error) {
+	db, err := pebble.Open(dir, &pebble.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open Pebble database: %s", err)
+	}
+
+	s := &storage{db: db, stopGC: make(chan struct{})}
+	go s.gc(gcInterval)
+
+	return s, nil
+}
+
+// update runs fn against an indexed write batch and commits it if fn
+// succeeds, giving callers an atomic get-modify-put much like
+// bolt.DB.Update and badger.DB.Update. Pebble's indexed batches alone
+// don't guarantee that: two concurrent batches can each read "not found"
+// from their own base snapshot and both commit, which would let two
+// concurrent Set/HashCreate/ListCreate calls on the same key both believe
+// they won an "already exists" check. mu serializes update itself to
+// close that race, since Pebble won't.
+func (s *storage) update(fn func(batch *pebble.Batch) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batch := s.db.NewIndexedBatch()
+	if err := fn(batch); err != nil {
+		batch.Close()
+		return err
+	}
+	return batch.Commit(pebble.Sync)
+}
+
+func dataKey(prefix byte, key string) []byte {
+	buf := make([]byte, 1+len(key))
+	buf[0] = prefix
+	copy(buf[1:], key)
+	return buf
+}
+
+// ttlKey builds a ttl-index key that sorts by expiration time first, so
+// the GC loop can range over just the expired prefix. dataKey is nil when
+// only used to build a scan boundary.
+func ttlKey(expireTime time.Time, dataKey []byte) []byte {
+	buf := make([]byte, 1+8+len(dataKey))
+	buf[0] = ttlPrefix
+	binary.BigEndian.PutUint64(buf[1:], uint64(expireTime.UnixNano()))
+	copy(buf[9:], dataKey)
+	return buf
+}
+
+func (s *storage) gc(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopGC:
+			return
+		case <-ticker.C:
+		}
+
+		lower := []byte{ttlPrefix}
+		upper := ttlKey(time.Now(), nil)
+
+		var expiredTTLKeys, expiredDataKeys [][]byte
+		it, err := s.db.NewIter(&pebble.IterOptions{LowerBound: lower, UpperBound: upper})
+		if err != nil {
+			continue
+		}
+		for it.First(); it.Valid(); it.Next() {
+			expiredTTLKeys = append(expiredTTLKeys, append([]byte{}, it.Key()...))
+			expiredDataKeys = append(expiredDataKeys, append([]byte{}, it.Value()...))
+		}
+		it.Close()
+
+		if len(expiredTTLKeys) == 0 {
+			continue
+		}
+
+		batch := s.db.NewBatch()
+		for i := range expiredTTLKeys {
+			batch.Delete(expiredTTLKeys[i], nil)
+			batch.Delete(expiredDataKeys[i], nil)
+		}
+		batch.Commit(pebble.Sync)
+	}
+}
+
+// StopGC stops the background GC goroutine started by NewStorage. It is
+// used by a cluster wrapping this storage to disable independent,
+// wall-clock-driven expiry in favor of leader-driven, replicated expiry
+// via ExpiringKeys. Calling it more than once panics, same as closing any
+// other channel twice.
+func (s *storage) StopGC() {
+	close(s.stopGC)
+}
+
+// ExpiringKeys returns every live key whose expiration time is at or
+// before cutoff, without deleting them. A cluster leader uses this to
+// discover expired keys and propose their removal through Raft so every
+// node deletes them at the same point in the replicated log instead of
+// each node's own GC racing to expire the same key independently.
+func (s *storage) ExpiringKeys(cutoff time.Time) ([]string, error) {
+	lower := []byte{ttlPrefix}
+	upper := ttlKey(cutoff.Add(time.Nanosecond), nil)
+
+	it, err := s.db.NewIter(&pebble.IterOptions{LowerBound: lower, UpperBound: upper})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var keys []string
+	for it.First(); it.Valid(); it.Next() {
+		dataKey := it.Value()
+		if len(dataKey) < 1 {
+			continue
+		}
+		keys = append(keys, string(dataKey[1:]))
+	}
+	return keys, nil
+}
+
+func decodeItem(data []byte) (*commonStorage.Item, error) {
+	var item commonStorage.Item
+	if err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func encodeItem(item *commonStorage.Item) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(item); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func getRaw(batch *pebble.Batch, key []byte) ([]byte, error) {
+	value, closer, err := batch.Get(key)
+	if err == pebble.ErrNotFound {
+		return nil, commonStorage.KeyNotExistsError
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	return append([]byte{}, value...), nil
+}
+
+// getItem looks up the item stored under key with the given type prefix.
+// It also returns the data key it was found at, so callers can pass it
+// straight to saveItem/deleteItem.
+func (s *storage) getItem(batch *pebble.Batch, prefix byte, key string) (*commonStorage.Item, []byte, error) {
+	dk := dataKey(prefix, key)
+	raw, err := getRaw(batch, dk)
+	if err != nil {
+		return nil, dk, err
+	}
+
+	item, err := decodeItem(raw)
+	if err != nil {
+		return nil, dk, err
+	}
+	if !item.IsAlive() {
+		return nil, dk, commonStorage.KeyNotExistsError
+	}
+	return item, dk, nil
+}
+
+// findItem looks up key regardless of its type, which is what operations
+// like Set, Delete and Expire need since keys share a single namespace
+// across strings, hashes and lists.
+func (s *storage) findItem(batch *pebble.Batch, key string) (*commonStorage.Item, []byte, error) {
+	for _, prefix := range typePrefixes {
+		if item, dk, err := s.getItem(batch, prefix, key); err == nil {
+			return item, dk, nil
+		}
+	}
+	return nil, nil, commonStorage.KeyNotExistsError
+}
+
+func (s *storage) saveItem(batch *pebble.Batch, dataKey []byte, item *commonStorage.Item, oldExpireTime time.Time) error {
+	if !oldExpireTime.IsZero() {
+		if err := batch.Delete(ttlKey(oldExpireTime, dataKey), nil); err != nil {
+			return err
+		}
+	}
+
+	encoded, err := encodeItem(item)
+	if err != nil {
+		return err
+	}
+	if err := batch.Set(dataKey, encoded, nil); err != nil {
+		return err
+	}
+
+	if !item.ExpireTime.IsZero() {
+		if err := batch.Set(ttlKey(item.ExpireTime, dataKey), dataKey, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *storage) deleteItem(batch *pebble.Batch, dataKey []byte, item *commonStorage.Item) error {
+	if err := batch.Delete(dataKey, nil); err != nil {
+		return err
+	}
+	if !item.ExpireTime.IsZero() {
+		return batch.Delete(ttlKey(item.ExpireTime, dataKey), nil)
+	}
+	return nil
+}
+
+func (s *storage) getHash(batch *pebble.Batch, key string) (commonStorage.Hash, error) {
+	item, _, err := s.getItem(batch, hashPrefix, key)
+	if err != nil {
+		return nil, err
+	}
+	return item.CastHash()
+}
+
+func (s *storage) getList(batch *pebble.Batch, key string) (*commonStorage.List, error) {
+	item, _, err := s.getItem(batch, listPrefix, key)
+	if err != nil {
+		return nil, err
+	}
+	return item.CastList()
+}
+
+// Keys returns list of all keys
+func (s *storage) Keys() (keys []string) {
+	it, err := s.db.NewIter(&pebble.IterOptions{})
+	if err != nil {
+		return nil
+	}
+	defer it.Close()
+
+	for it.First(); it.Valid(); it.Next() {
+		key := it.Key()
+		if len(key) == 0 || key[0] == ttlPrefix {
+			continue
+		}
+
+		item, err := decodeItem(it.Value())
+		if err != nil {
+			continue
+		}
+		if item.IsAlive() {
+			keys = append(keys, string(key[1:]))
+		}
+	}
+	sort.Strings(keys)
+	return
+}
+
+// Expire sets new key ttl
+func (s *storage) Expire(key string, ttl uint64) error {
+	return s.update(func(batch *pebble.Batch) error {
+		item, dk, err := s.findItem(batch, key)
+		if err != nil {
+			return err
+		}
+
+		oldExpireTime := item.ExpireTime
+		item.SetTTL(ttl)
+		return s.saveItem(batch, dk, item, oldExpireTime)
+	})
+}
+
+// Get value of specified key. Error will occur if key doesn't exist or key type is not string.
+func (s *storage) Get(key string) (value string, err error) {
+	batch := s.db.NewIndexedBatch()
+	defer batch.Close()
+
+	item, _, err := s.getItem(batch, stringPrefix, key)
+	if err != nil {
+		return "", err
+	}
+	return item.CastString()
+}
+
+// Set value of specified key with ttl. Use zero ttl if key should exist forever.
+// Error will occur if key already exists.
+func (s *storage) Set(key, value string, ttl uint64) error {
+	return s.update(func(batch *pebble.Batch) error {
+		if _, _, err := s.findItem(batch, key); err == nil {
+			return commonStorage.KeyAlreadyExistsError
+		}
+
+		item := commonStorage.NewItem(value, ttl)
+		return s.saveItem(batch, dataKey(stringPrefix, key), item, time.Time{})
+	})
+}
+
+// Update value of specified key. Error will occur if key doesn't exist or key type is not string.
+func (s *storage) Update(key, value string) error {
+	return s.update(func(batch *pebble.Batch) error {
+		item, dk, err := s.getItem(batch, stringPrefix, key)
+		if err != nil {
+			return err
+		}
+
+		item.Value = value
+		return s.saveItem(batch, dk, item, item.ExpireTime)
+	})
+}
+
+// Delete specified key. Error will occur if key doesn't exist. It works for any key type.
+func (s *storage) Delete(key string) error {
+	return s.update(func(batch *pebble.Batch) error {
+		item, dk, err := s.findItem(batch, key)
+		if err != nil {
+			return err
+		}
+		return s.deleteItem(batch, dk, item)
+	})
+}
+
+// HashCreate creates new hash with specified key and ttl. Use zero ttl if key should exist forever.
+func (s *storage) HashCreate(key string, ttl uint64) error {
+	return s.update(func(batch *pebble.Batch) error {
+		if _, _, err := s.findItem(batch, key); err == nil {
+			return commonStorage.KeyAlreadyExistsError
+		}
+
+		item := commonStorage.NewItem(make(commonStorage.Hash), ttl)
+		return s.saveItem(batch, dataKey(hashPrefix, key), item, time.Time{})
+	})
+}
+
+// HashGet returns value of specified field of key.
+// Error will occur if key or field doesn't exist or key type is not hash.
+func (s *storage) HashGet(key, field string) (value string, err error) {
+	batch := s.db.NewIndexedBatch()
+	defer batch.Close()
+
+	hash, err := s.getHash(batch, key)
+	if err != nil {
+		return "", err
+	}
+	return hash.GetValue(field)
+}
+
+// HashGetAll returns all hash values of specified key. Error will occur if key doesn't exist or key type is not hash.
+func (s *storage) HashGetAll(key string) (map[string]string, error) {
+	batch := s.db.NewIndexedBatch()
+	defer batch.Close()
+
+	return s.getHash(batch, key)
+}
+
+// HashSet sets field value of specified key. Error will occur if key doesn't exist or key type is not hash.
+func (s *storage) HashSet(key, field, value string) error {
+	return s.update(func(batch *pebble.Batch) error {
+		item, dk, err := s.getItem(batch, hashPrefix, key)
+		if err != nil {
+			if _, _, err := s.findItem(batch, key); err == nil {
+				return commonStorage.KeyHashTypeError
+			}
+			item = commonStorage.NewItem(make(commonStorage.Hash), 0)
+			dk = dataKey(hashPrefix, key)
+		}
+		hash, err := item.CastHash()
+		if err != nil {
+			return err
+		}
+		hash[field] = value
+
+		return s.saveItem(batch, dk, item, item.ExpireTime)
+	})
+}
+
+// HashDelete deletes field from hash. Error will occur if key doesn't exist or key type is not hash.
+func (s *storage) HashDelete(key, field string) error {
+	return s.update(func(batch *pebble.Batch) error {
+		item, dk, err := s.getItem(batch, hashPrefix, key)
+		if err != nil {
+			return err
+		}
+		hash, err := item.CastHash()
+		if err != nil {
+			return err
+		}
+		if _, err := hash.GetValue(field); err != nil {
+			return err
+		}
+		delete(hash, field)
+		return s.saveItem(batch, dk, item, item.ExpireTime)
+	})
+}
+
+// HashLen returns count of hash fields. Error will occur if key doesn't exist or key type is not hash.
+func (s *storage) HashLen(key string) (int, error) {
+	batch := s.db.NewIndexedBatch()
+	defer batch.Close()
+
+	hash, err := s.getHash(batch, key)
+	if err != nil {
+		return 0, err
+	}
+	return len(hash), nil
+}
+
+// HashKeys returns list of all hash fields. Error will occur if key doesn't exist or key type is not hash.
+func (s *storage) HashKeys(key string) (keys []string, err error) {
+	batch := s.db.NewIndexedBatch()
+	defer batch.Close()
+
+	hash, err := s.getHash(batch, key)
+	if err != nil {
+		return nil, err
+	}
+	for field := range hash {
+		keys = append(keys, field)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// ListCreate creates new list with specified key and ttl. Use zero duration if key should exist forever.
+func (s *storage) ListCreate(key string, ttl uint64) error {
+	return s.update(func(batch *pebble.Batch) error {
+		if _, _, err := s.findItem(batch, key); err == nil {
+			return commonStorage.KeyAlreadyExistsError
+		}
+
+		item := commonStorage.NewItem(commonStorage.NewList(), ttl)
+		return s.saveItem(batch, dataKey(listPrefix, key), item, time.Time{})
+	})
+}
+
+// ListLeftPop pops value from the list beginning.
+// Error will occur if key doesn't exist, key type is not list or list is empty.
+func (s *storage) ListLeftPop(key string) (value string, err error) {
+	err = s.update(func(batch *pebble.Batch) error {
+		item, dk, err := s.getItem(batch, listPrefix, key)
+		if err != nil {
+			return err
+		}
+		list, err := item.CastList()
+		if err != nil {
+			return err
+		}
+		value, err = list.LeftPop()
+		if err != nil {
+			return err
+		}
+		return s.saveItem(batch, dk, item, item.ExpireTime)
+	})
+	return
+}
+
+// ListRightPop pops value from the list ending.
+// Error will occur if key doesn't exist, key type is not list or list is empty.
+func (s *storage) ListRightPop(key string) (value string, err error) {
+	err = s.update(func(batch *pebble.Batch) error {
+		item, dk, err := s.getItem(batch, listPrefix, key)
+		if err != nil {
+			return err
+		}
+		list, err := item.CastList()
+		if err != nil {
+			return err
+		}
+		value, err = list.RightPop()
+		if err != nil {
+			return err
+		}
+		return s.saveItem(batch, dk, item, item.ExpireTime)
+	})
+	return
+}
+
+// ListLeftPush adds value to the list beginning. Error will occur if key doesn't exist or key type is not list.
+func (s *storage) ListLeftPush(key, value string) error {
+	return s.update(func(batch *pebble.Batch) error {
+		item, dk, err := s.getItem(batch, listPrefix, key)
+		if err != nil {
+			return err
+		}
+		list, err := item.CastList()
+		if err != nil {
+			return err
+		}
+		list.LeftPush(value)
+		return s.saveItem(batch, dk, item, item.ExpireTime)
+	})
+}
+
+// ListRightPush adds value to the list ending. Error will occur if key doesn't exist or key type is not list.
+func (s *storage) ListRightPush(key, value string) error {
+	return s.update(func(batch *pebble.Batch) error {
+		item, dk, err := s.getItem(batch, listPrefix, key)
+		if err != nil {
+			return err
+		}
+		list, err := item.CastList()
+		if err != nil {
+			return err
+		}
+		list.RightPush(value)
+		return s.saveItem(batch, dk, item, item.ExpireTime)
+	})
+}
+
+// ListLen returns count of elements in the list. Error will occur if key doesn't exist or key type is not list.
+func (s *storage) ListLen(key string) (int, error) {
+	batch := s.db.NewIndexedBatch()
+	defer batch.Close()
+
+	list, err := s.getList(batch, key)
+	if err != nil {
+		return 0, err
+	}
+	return list.Len(), nil
+}
+
+// ListRange returns list of elements from the list from start to stop index.
+// Error will occur if key doesn't exist or key type is not list.
+func (s *storage) ListRange(key string, start, stop int) ([]string, error) {
+	batch := s.db.NewIndexedBatch()
+	defer batch.Close()
+
+	list, err := s.getList(batch, key)
+	if err != nil {
+		return nil, err
+	}
+	return list.Range(start, stop), nil
+}
+
+// Dump walks every live key under a single iterator snapshot, so the
+// snapshot it produces is consistent without blocking concurrent reads or
+// writes, and calls fn with each one in turn. Keys are visited in
+// Pebble's natural key order, which is stable across calls, so after (the
+// Cursor of a previously dumped Record) can be used to resume a dump that
+// was interrupted partway through.
+func (s *storage) Dump(after string, fn func(commonStorage.Record) error) error {
+	it, err := s.db.NewIter(&pebble.IterOptions{})
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	var valid bool
+	if after == "" {
+		valid = it.First()
+	} else {
+		valid = it.SeekGE([]byte(after))
+		if valid && bytes.Equal(it.Key(), []byte(after)) {
+			valid = it.Next()
+		}
+	}
+
+	for ; valid; valid = it.Next() {
+		key := it.Key()
+		if len(key) == 0 || key[0] == ttlPrefix {
+			continue
+		}
+
+		item, err := decodeItem(it.Value())
+		if err != nil {
+			return err
+		}
+		if !item.IsAlive() {
+			continue
+		}
+
+		record, err := dataKeyToRecord(key, item)
+		if err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load writes record into storage, recreating whichever type it holds
+// under its original ttl. It does not check whether the key already
+// exists, since Loader is documented as only being safe to use against a
+// fresh, empty instance.
+func (s *storage) Load(record commonStorage.Record) error {
+	return s.update(func(batch *pebble.Batch) error {
+		prefix, value, err := recordValue(record)
+		if err != nil {
+			return err
+		}
+
+		item := commonStorage.NewItem(value, record.TTL)
+		return s.saveItem(batch, dataKey(prefix, record.Key), item, time.Time{})
+	})
+}
+
+// dataKeyToRecord turns a raw data key entry into the commonStorage.Record
+// Dump hands to its callback, using the entry's own key as the resume
+// cursor.
+func dataKeyToRecord(dk []byte, item *commonStorage.Item) (commonStorage.Record, error) {
+	record := commonStorage.Record{
+		Key:    string(dk[1:]),
+		TTL:    remainingTTL(item.ExpireTime),
+		Cursor: string(dk),
+	}
+
+	switch value := item.Value.(type) {
+	case string:
+		record.Type = commonStorage.StringRecord
+		record.String = value
+	case commonStorage.Hash:
+		record.Type = commonStorage.HashRecord
+		record.Hash = value
+	case *commonStorage.List:
+		record.Type = commonStorage.ListRecord
+		record.List = value.Items
+	default:
+		return commonStorage.Record{}, fmt.Errorf("unknown stored value type %T", item.Value)
+	}
+	return record, nil
+}
+
+// recordValue turns a commonStorage.Record back into the type prefix and
+// storage.Item value Load should save it under.
+func recordValue(record commonStorage.Record) (byte, interface{}, error) {
+	switch record.Type {
+	case commonStorage.StringRecord:
+		return stringPrefix, record.String, nil
+	case commonStorage.HashRecord:
+		return hashPrefix, commonStorage.Hash(record.Hash), nil
+	case commonStorage.ListRecord:
+		return listPrefix, &commonStorage.List{Items: record.List}, nil
+	default:
+		return 0, nil, fmt.Errorf("unknown record type %q", record.Type)
+	}
+}
+
+// remainingTTL converts an absolute expiry time into the seconds-remaining
+// form storage.Record carries, rounding up so a key that has not actually
+// expired yet is never rounded down to "never expires".
+func remainingTTL(expireTime time.Time) uint64 {
+	if expireTime.IsZero() {
+		return 0
+	}
+	remaining := time.Until(expireTime)
+	if remaining <= 0 {
+		return 0
+	}
+	return uint64(math.Ceil(remaining.Seconds()))
+}
@@ -0,0 +1,45 @@
+// Package registry maps storage DSN schemes (memory://, boltdb://...) to
+// the constructor that builds a storage.Storage from one. Backend
+// packages register themselves from an init() function, so wiring in a
+// new persistent engine only requires importing its package for its side
+// effects, without server or main.go knowing about it directly.
+package registry
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/Barberrrry/jcache/server/storage"
+)
+
+// Factory builds a storage.Storage from a parsed DSN of the scheme it was
+// registered under.
+type Factory func(dsn *url.URL) (storage.Storage, error)
+
+var factories = map[string]Factory{}
+
+// Register associates scheme with factory. It panics on a duplicate
+// registration, since that always indicates two backends were compiled
+// in for the same scheme by mistake.
+func Register(scheme string, factory Factory) {
+	if _, exists := factories[scheme]; exists {
+		panic(fmt.Sprintf("storage scheme %q is already registered", scheme))
+	}
+	factories[scheme] = factory
+}
+
+// Open parses dsn and builds the storage.Storage registered for its
+// scheme.
+func Open(dsn string) (storage.Storage, error) {
+	url, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage DSN %q: %s", dsn, err)
+	}
+
+	factory, ok := factories[url.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unknown storage scheme %q", url.Scheme)
+	}
+
+	return factory(url)
+}
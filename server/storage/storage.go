@@ -2,6 +2,7 @@ package storage
 
 import (
 	"errors"
+	"time"
 )
 
 type Storage interface {
@@ -27,6 +28,67 @@ type Storage interface {
 	ListRange(key string, start, stop int) ([]string, error)
 }
 
+// RecordType identifies which kind of value a Record carries.
+type RecordType string
+
+const (
+	StringRecord RecordType = "string"
+	HashRecord   RecordType = "hash"
+	ListRecord   RecordType = "list"
+)
+
+// Record is a single key as read back by Dump, carrying enough to
+// recreate it with Load without either side knowing anything about the
+// backend that produced or consumes it.
+type Record struct {
+	Key    string
+	Type   RecordType
+	TTL    uint64 // seconds remaining before expiry, 0 if the key never expires
+	String string
+	Hash   map[string]string
+	List   []string
+	// Cursor identifies Record's position in the backend's dump order. A
+	// caller that loses its connection mid-transfer can pass the Cursor
+	// of the last record it applied back into Dump's after argument to
+	// resume immediately past it instead of starting over.
+	Cursor string
+}
+
+// Dumper is implemented by backends that can walk every live key under a
+// single consistent read without blocking concurrent traffic. jcache-dump
+// uses it through package snapshot; no protocol command calls it.
+type Dumper interface {
+	// Dump calls fn for every live key in the backend's natural dump
+	// order. If after is non-empty, keys up to and including the one it
+	// names are skipped, so a broken transfer can resume where it left
+	// off instead of starting over.
+	Dump(after string, fn func(Record) error) error
+}
+
+// Loader is implemented by backends that can be seeded from a stream of
+// Records produced by Dump. jcache-load uses it through package snapshot;
+// no protocol command calls it. It is meant for populating a fresh, empty
+// instance, not for merging into a live one.
+type Loader interface {
+	Load(Record) error
+}
+
+// ExpiryScanner is implemented by backends that can report which live
+// keys have an expiration time at or before cutoff without deleting them.
+// A cluster leader uses it to discover expired keys and replicate their
+// removal via the Raft log, rather than leaving every node's own GC to
+// expire the same key at a different wall-clock moment.
+type ExpiryScanner interface {
+	ExpiringKeys(cutoff time.Time) ([]string, error)
+}
+
+// GCStopper is implemented by backends whose background expiry GC can be
+// stopped. A cluster calls it once on wrap-up so per-node expiry stops
+// racing the leader-driven, replicated expiry loop.
+type GCStopper interface {
+	StopGC()
+}
+
 var (
 	KeyNotExistsError     = errors.New("Key does not exist")
 	KeyAlreadyExistsError = errors.New("Key already exists")
@@ -35,4 +97,13 @@ var (
 	KeyStringTypeError    = errors.New("Key type is not string")
 	KeyHashTypeError      = errors.New("Key type is not hash")
 	KeyListTypeError      = errors.New("Key type is not list")
+
+	// NotLeaderError is returned by a replicated Storage implementation
+	// (see server/cluster) when the local node cannot currently service a
+	// call because it is not the Raft leader: always for a write, and for a
+	// read when stale, locally-served reads are disabled. Callers that can tolerate eventual consistency,
+	// like user.Store's one-time bootstrap, match it with errors.Is to
+	// treat the call as "not this node's job right now" instead of a
+	// hard failure.
+	NotLeaderError = errors.New("storage: this node is not the leader")
 )
@@ -0,0 +1,236 @@
+// Package user implements a bcrypt-hashed user store persisted inside a
+// storage.Storage backend, replacing a flat htpasswd file so credentials
+// survive restarts on any storage engine (memory, boltdb, badger, pebble).
+package user
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	commonStorage "github.com/Barberrrry/jcache/server/storage"
+)
+
+// storeKey is the reserved hash key users are stored under, out of the
+// way of any key a client could legitimately create.
+const storeKey = "__users__"
+
+var (
+	UserAlreadyExistsError = errors.New("User already exists")
+	UserNotExistsError     = errors.New("User does not exist")
+	WrongPasswordError     = errors.New("Wrong password")
+)
+
+type record struct {
+	PasswordHash string `json:"password_hash"`
+	Admin        bool   `json:"admin"`
+}
+
+// Store keeps user records inside the reserved storeKey hash of the
+// wrapped storage.
+type Store struct {
+	storage commonStorage.Storage
+}
+
+// NewStore prepares storage to hold users, creating the reserved hash key
+// if it doesn't already exist. Against a replicated storage this call can
+// land on a follower, which cannot create the key itself; that is left to
+// the leader's own NewStore call to replicate, so commonStorage.NotLeaderError
+// is tolerated here rather than treated as a setup failure.
+func NewStore(storage commonStorage.Storage) (*Store, error) {
+	err := storage.HashCreate(storeKey, 0)
+	if err != nil && err != commonStorage.KeyAlreadyExistsError && !errors.Is(err, commonStorage.NotLeaderError) {
+		return nil, err
+	}
+	return &Store{storage: storage}, nil
+}
+
+// Add creates a new user with a bcrypt-hashed password.
+// Error will occur if the user already exists.
+func (s *Store) Add(name, password string, admin bool) error {
+	if _, err := s.storage.HashGet(storeKey, name); err == nil {
+		return UserAlreadyExistsError
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return s.put(name, record{PasswordHash: string(hash), Admin: admin})
+}
+
+// Delete removes a user. Error will occur if the user doesn't exist.
+func (s *Store) Delete(name string) error {
+	if err := s.storage.HashDelete(storeKey, name); err == commonStorage.FieldNotExistError {
+		return UserNotExistsError
+	} else {
+		return err
+	}
+}
+
+// Passwd changes name's password, verifying oldPassword first.
+func (s *Store) Passwd(name, oldPassword, newPassword string) error {
+	r, err := s.get(name)
+	if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(r.PasswordHash), []byte(oldPassword)) != nil {
+		return WrongPasswordError
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	r.PasswordHash = string(hash)
+
+	return s.put(name, r)
+}
+
+// List returns the names of every registered user.
+func (s *Store) List() ([]string, error) {
+	return s.storage.HashKeys(storeKey)
+}
+
+// Authenticate reports whether password is correct for name. A missing
+// user is treated as a failed authentication rather than an error.
+func (s *Store) Authenticate(name, password string) (bool, error) {
+	r, err := s.get(name)
+	if err == UserNotExistsError {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return bcrypt.CompareHashAndPassword([]byte(r.PasswordHash), []byte(password)) == nil, nil
+}
+
+// IsAdmin reports whether name has the admin role.
+func (s *Store) IsAdmin(name string) (bool, error) {
+	r, err := s.get(name)
+	if err != nil {
+		return false, err
+	}
+	return r.Admin, nil
+}
+
+// Bootstrap creates a randomly-generated "admin" user the first time the
+// store is empty, printing the password once to stderr so an operator can
+// log in and provision real accounts. Against a replicated storage, only
+// the leader can actually discover and create the admin user; a follower
+// that calls Bootstrap gets commonStorage.NotLeaderError from the List
+// read below and treats it as nothing to do, relying on the leader's
+// bootstrap to replicate.
+func (s *Store) Bootstrap() error {
+	names, err := s.List()
+	if err != nil {
+		if errors.Is(err, commonStorage.NotLeaderError) {
+			return nil
+		}
+		return err
+	}
+	if len(names) > 0 {
+		return nil
+	}
+
+	password, err := randomPassword()
+	if err != nil {
+		return err
+	}
+	if err := s.Add("admin", password, true); err != nil {
+		return err
+	}
+
+	log.Printf(`generated initial admin user "admin" with password "%s"`, password)
+	return nil
+}
+
+func (s *Store) get(name string) (record, error) {
+	encoded, err := s.storage.HashGet(storeKey, name)
+	if err == commonStorage.FieldNotExistError {
+		return record{}, UserNotExistsError
+	}
+	if err != nil {
+		return record{}, err
+	}
+	return decodeRecord(encoded)
+}
+
+func (s *Store) put(name string, r record) error {
+	encoded, err := encodeRecord(r)
+	if err != nil {
+		return err
+	}
+	return s.storage.HashSet(storeKey, name, encoded)
+}
+
+func encodeRecord(r record) (string, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func decodeRecord(data string) (record, error) {
+	var r record
+	err := json.Unmarshal([]byte(data), &r)
+	return r, err
+}
+
+func randomPassword() (string, error) {
+	buf := make([]byte, 18)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// ImportHtpasswd seeds store from an Apache htpasswd file and returns how
+// many users were imported. Only bcrypt-hashed entries ($2a$/$2b$/$2y$)
+// can be carried over as-is; entries hashed with crypt or MD5-apr1 can't
+// be converted without the original password and are skipped.
+func ImportHtpasswd(store *Store, path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read htpasswd file: %s", err)
+	}
+
+	imported := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name, hash := parts[0], parts[1]
+
+		if !isBcryptHash(hash) {
+			log.Printf(`skipping user "%s": htpasswd hash is not bcrypt`, name)
+			continue
+		}
+
+		if err := store.put(name, record{PasswordHash: hash, Admin: name == "admin"}); err != nil {
+			return imported, err
+		}
+		imported++
+	}
+
+	return imported, nil
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}